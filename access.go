@@ -0,0 +1,160 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"fmt"
+)
+
+//
+// Access and body commands
+//
+
+// Trunk identifies the trunk for an actuate_trunk command.
+type Trunk string
+
+// Trunk values
+const (
+	TrunkFront Trunk = "front"
+	TrunkRear  Trunk = "rear"
+)
+
+// WindowCommand is the operation for a window_control command.
+type WindowCommand string
+
+// WindowCommand values
+const (
+	WindowVent  WindowCommand = "vent"
+	WindowClose WindowCommand = "close"
+)
+
+// SunRoofCommand is the operation for a sun_roof_control command.
+type SunRoofCommand string
+
+// SunRoofCommand values
+const (
+	SunRoofVent  SunRoofCommand = "vent"
+	SunRoofClose SunRoofCommand = "close"
+)
+
+// locationParams are the parameters for commands that need to know
+// where the vehicle (or the user) is.
+type locationParams struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// AnyDoorOpen returns true if any of the doors are open.
+func (vs *VehicleState) AnyDoorOpen() bool {
+	return vs.Df != 0 || vs.Pf != 0 || vs.Dr != 0 || vs.Pr != 0
+}
+
+// AnyWindowOpen returns true if any of the windows are open (or vented).
+func (vs *VehicleState) AnyWindowOpen() bool {
+	return vs.FdWindow != 0 || vs.FpWindow != 0 || vs.RdWindow != 0 || vs.RpWindow != 0
+}
+
+// TrunkOpen returns true if the given trunk is open.
+func (vs *VehicleState) TrunkOpen(which Trunk) bool {
+	switch which {
+	case TrunkFront:
+		return vs.Ft != 0
+	case TrunkRear:
+		return vs.Rt != 0
+	}
+	return false
+}
+
+// DoorLock locks the doors.
+func (c *Client) DoorLock(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "door_lock", nil)
+}
+
+// DoorUnlock unlocks the doors.
+func (c *Client) DoorUnlock(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "door_unlock", nil)
+}
+
+// ActuateTrunk opens (or, on some vehicles, closes) the front or
+// rear trunk.
+func (c *Client) ActuateTrunk(ctx context.Context, ids string, which Trunk) (*CommandResult, error) {
+	if which != TrunkFront && which != TrunkRear {
+		return nil, fmt.Errorf("invalid trunk %q", which)
+	}
+	params := struct {
+		WhichTrunk Trunk `json:"which_trunk"`
+	}{which}
+	return c.PostCommand(ctx, ids, "actuate_trunk", &params)
+}
+
+// WindowControl vents or closes all of the windows.  Closing the
+// windows requires that the user be near the vehicle, so the API
+// wants the vehicle's coordinates, which are taken from ds.  If ds is
+// nil, the vehicle's DriveState is retrieved first.
+func (c *Client) WindowControl(ctx context.Context, ids string, command WindowCommand, ds *DriveState) (*CommandResult, error) {
+	if command != WindowVent && command != WindowClose {
+		return nil, fmt.Errorf("invalid window command %q", command)
+	}
+	loc, err := c.vehicleLocation(ctx, ids, ds)
+	if err != nil {
+		return nil, err
+	}
+	params := struct {
+		Command WindowCommand `json:"command"`
+		locationParams
+	}{command, *loc}
+	return c.PostCommand(ctx, ids, "window_control", &params)
+}
+
+// SunRoofControl vents or closes the sunroof.
+func (c *Client) SunRoofControl(ctx context.Context, ids string, state SunRoofCommand) (*CommandResult, error) {
+	if state != SunRoofVent && state != SunRoofClose {
+		return nil, fmt.Errorf("invalid sunroof command %q", state)
+	}
+	params := struct {
+		State SunRoofCommand `json:"state"`
+	}{state}
+	return c.PostCommand(ctx, ids, "sun_roof_control", &params)
+}
+
+// HonkHorn honks the horn.
+func (c *Client) HonkHorn(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "honk_horn", nil)
+}
+
+// FlashLights flashes the headlights.
+func (c *Client) FlashLights(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "flash_lights", nil)
+}
+
+// TriggerHomelink opens or closes the garage door associated with
+// the vehicle's location (VehicleState.HomelinkNearby should be true).
+// The coordinates are taken from ds; if ds is nil, the vehicle's
+// DriveState is retrieved first.
+func (c *Client) TriggerHomelink(ctx context.Context, ids string, ds *DriveState) (*CommandResult, error) {
+	loc, err := c.vehicleLocation(ctx, ids, ds)
+	if err != nil {
+		return nil, err
+	}
+	return c.PostCommand(ctx, ids, "trigger_homelink", loc)
+}
+
+// vehicleLocation returns the coordinates of a vehicle from ds, or
+// from its current DriveState if ds is nil.
+func (c *Client) vehicleLocation(ctx context.Context, ids string, ds *DriveState) (*locationParams, error) {
+	if ds == nil {
+		var err error
+		ds, err = c.GetDriveState(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &locationParams{Lat: ds.Latitude, Lon: ds.Longitude}, nil
+}
@@ -0,0 +1,226 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+//
+// Multi-account token cache
+//
+// The token cache file can hold tokens for several accounts.  A cache
+// that only holds a token for the default account is written in the
+// original format (a single Token), so that older versions of this
+// package can still read it.
+//
+
+// DefaultAccount is the name of the account used when none is given.
+const DefaultAccount = ""
+
+// A TokenCacheEntry is the Token for one account (and region) in the
+// token cache.
+type TokenCacheEntry struct {
+	Account string `json:"account"` // usually the account's email address
+	Alias   string `json:"alias,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Token   *Token `json:"token"`
+}
+
+// matches returns true if an entry is for the given account (email
+// address or alias) and region.  An empty region matches any region.
+func (e *TokenCacheEntry) matches(account string, region string) bool {
+	if e.Account != account && (e.Alias == "" || e.Alias != account) {
+		return false
+	}
+	return region == "" || e.Region == region
+}
+
+// tokenCache is the multi-account cache file format.
+type tokenCache struct {
+	Accounts []*TokenCacheEntry `json:"accounts"`
+}
+
+// loadTokenCache reads the entries in a cache file.  A missing file
+// is an empty cache.
+func loadTokenCache(path string) ([]*TokenCacheEntry, error) {
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Figure out which format we have
+	var probe map[string]json.RawMessage
+	err = json.Unmarshal(body, &probe)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := probe["accounts"]; !ok {
+		var t Token
+		err = json.Unmarshal(body, &t)
+		if err != nil {
+			return nil, err
+		}
+		return []*TokenCacheEntry{{Account: DefaultAccount, Token: &t}}, nil
+	}
+
+	var tc tokenCache
+	err = json.Unmarshal(body, &tc)
+	if err != nil {
+		return nil, err
+	}
+	return tc.Accounts, nil
+}
+
+// saveTokenCache writes the entries to a cache file, in the original
+// format if possible.
+func saveTokenCache(path string, entries []*TokenCacheEntry) error {
+	var body []byte
+	var err error
+	if len(entries) == 1 && *entries[0] == (TokenCacheEntry{Account: DefaultAccount, Token: entries[0].Token}) {
+		body, err = json.Marshal(entries[0].Token)
+	} else {
+		body, err = json.Marshal(&tokenCache{Accounts: entries})
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, body)
+}
+
+// ListCachedAccounts returns the entries in a token cache file.
+func ListCachedAccounts(path string) ([]TokenCacheEntry, error) {
+	entries, err := loadTokenCache(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []TokenCacheEntry
+	for _, e := range entries {
+		ret = append(ret, *e)
+	}
+	return ret, nil
+}
+
+// An AccountTokenStore is a TokenStore for one account in a
+// multi-account token cache file.  The account is selected by email
+// address or alias, and optionally by region.  It is not safe for
+// concurrent use by multiple processes.
+type AccountTokenStore struct {
+	Path    string
+	Account string // email address or alias
+	Region  string // any region if empty
+
+	// Alias is recorded for the account when a token is saved,
+	// if it is non-empty.
+	Alias string
+}
+
+// NewAccountTokenStore returns an AccountTokenStore for an account
+// in a cache file.
+func NewAccountTokenStore(path string, account string) *AccountTokenStore {
+	return &AccountTokenStore{Path: path, Account: account}
+}
+
+// find returns the index of the account's entry, or -1 if there is none.
+func (s *AccountTokenStore) find(entries []*TokenCacheEntry) (int, error) {
+	found := -1
+	for i, e := range entries {
+		if e.matches(s.Account, s.Region) {
+			if found >= 0 {
+				return -1, fmt.Errorf("account %q is ambiguous, specify a region", s.Account)
+			}
+			found = i
+		}
+	}
+	return found, nil
+}
+
+// name is the account name used in error messages.
+func (s *AccountTokenStore) name() string {
+	if s.Account == DefaultAccount {
+		return "default account"
+	}
+	return fmt.Sprintf("account %q", s.Account)
+}
+
+// Load returns the account's Token.
+func (s *AccountTokenStore) Load() (*Token, error) {
+	entries, err := loadTokenCache(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	i, err := s.find(entries)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || entries[i].Token == nil {
+		return nil, fmt.Errorf("%s: %s: %w", s.Path, s.name(), os.ErrNotExist)
+	}
+	return entries[i].Token, nil
+}
+
+// Save adds or replaces the account's Token.  A new entry is
+// recorded with the Region of the AccountTokenStore, or if that is
+// empty, the region the Token was issued for (see TokenRegion).  The
+// region isn't inferred for the default account, so that a cache
+// holding only its token stays in the original format.
+func (s *AccountTokenStore) Save(t *Token) error {
+	entries, err := loadTokenCache(s.Path)
+	if err != nil {
+		return err
+	}
+	i, err := s.find(entries)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		// Record the token's region if none was given, so that the
+		// same account can later be cached for another region too
+		region := s.Region
+		if region == "" && s.Account != DefaultAccount {
+			if r, err := TokenRegion(t); err == nil {
+				region = string(r)
+			}
+		}
+		entries = append(entries, &TokenCacheEntry{Account: s.Account, Region: region})
+		i = len(entries) - 1
+	}
+	entries[i].Token = t
+	if s.Alias != "" {
+		entries[i].Alias = s.Alias
+	}
+	return saveTokenCache(s.Path, entries)
+}
+
+// Delete removes the account from the cache.  The cache file is
+// removed when its last account is deleted.
+func (s *AccountTokenStore) Delete() error {
+	entries, err := loadTokenCache(s.Path)
+	if err != nil {
+		return err
+	}
+	i, err := s.find(entries)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		return fmt.Errorf("%s: %s: %w", s.Path, s.name(), os.ErrNotExist)
+	}
+	entries = append(entries[:i], entries[i+1:]...)
+	if len(entries) == 0 {
+		return os.Remove(s.Path)
+	}
+	return saveTokenCache(s.Path, entries)
+}
@@ -0,0 +1,105 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeJWT returns an unsigned JWT with the given claims.
+func fakeJWT(claims string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestAccountTokenStoreRegion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotesla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	eu := &Token{AccessToken: fakeJWT(`{"ou_code":"EU"}`)}
+	err = NewAccountTokenStore(path, "elon@example.com").Save(eu)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	na := &AccountTokenStore{Path: path, Account: "elon@example.com", Region: "na"}
+	err = na.Save(&Token{AccessToken: "owner"})
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	entries, err := ListCachedAccounts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Region != "eu" || entries[1].Region != "na" {
+		t.Fatalf("got entries %+v", entries)
+	}
+	tok, err := (&AccountTokenStore{Path: path, Account: "elon@example.com", Region: "eu"}).Load()
+	if err != nil || tok.AccessToken != eu.AccessToken {
+		t.Errorf("Load eu: got %v, %v", tok, err)
+	}
+	_, err = NewAccountTokenStore(path, "elon@example.com").Load()
+	if err == nil {
+		t.Error("Load without region succeeded for ambiguous account")
+	}
+}
+
+func TestAccountTokenStoreNilToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotesla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	err = ioutil.WriteFile(path, []byte(`{"accounts":[{"account":"elon@example.com","token":null}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewAccountTokenStore(path, "elon@example.com").Load()
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load: got %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestAccountTokenStoreDefaultFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotesla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	// A default token is saved in the original format, even if it
+	// says what region it was issued for
+	eu := &Token{AccessToken: fakeJWT(`{"ou_code":"EU"}`)}
+	err = NewAccountTokenStore(path, DefaultAccount).Save(eu)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tok Token
+	err = json.Unmarshal(body, &tok)
+	if err != nil || tok.AccessToken != eu.AccessToken {
+		t.Errorf("cache not in original format: %s", body)
+	}
+}
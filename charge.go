@@ -0,0 +1,78 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"fmt"
+)
+
+//
+// Charging commands
+//
+
+// ChargeStart starts charging the vehicle, if it is plugged in.
+func (c *Client) ChargeStart(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "charge_start", nil)
+}
+
+// ChargeStop stops charging the vehicle.
+func (c *Client) ChargeStop(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "charge_stop", nil)
+}
+
+// ChargePortDoorOpen opens the charge port door, or unlatches the
+// charge cable if it is plugged in.
+func (c *Client) ChargePortDoorOpen(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "charge_port_door_open", nil)
+}
+
+// ChargePortDoorClose closes the charge port door on vehicles with a
+// motorized charge port.
+func (c *Client) ChargePortDoorClose(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "charge_port_door_close", nil)
+}
+
+// ChargeStandard sets the charge limit to the "standard" value
+// (ChargeState.ChargeLimitSocStd).
+func (c *Client) ChargeStandard(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "charge_standard", nil)
+}
+
+// ChargeMaxRange sets the charge limit to the maximum value
+// (ChargeState.ChargeLimitSocMax).
+func (c *Client) ChargeMaxRange(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "charge_max_range", nil)
+}
+
+// SetChargeLimit sets the charge limit to a percentage of battery
+// capacity.  The vehicle enforces its own limits, which can be found
+// in ChargeState.ChargeLimitSocMin and ChargeState.ChargeLimitSocMax.
+func (c *Client) SetChargeLimit(ctx context.Context, ids string, percent int) (*CommandResult, error) {
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("charge limit %d%% out of range", percent)
+	}
+	params := struct {
+		Percent int `json:"percent"`
+	}{percent}
+	return c.PostCommand(ctx, ids, "set_charge_limit", &params)
+}
+
+// SetChargingAmps sets the charging current, in amps.  The vehicle
+// won't charge at more than the pilot current advertised by the
+// charger (ChargeState.ChargerPilotCurrent).
+func (c *Client) SetChargingAmps(ctx context.Context, ids string, amps int) (*CommandResult, error) {
+	if amps <= 0 {
+		return nil, fmt.Errorf("charging current %dA out of range", amps)
+	}
+	params := struct {
+		ChargingAmps int `json:"charging_amps"`
+	}{amps}
+	return c.PostCommand(ctx, ids, "set_charging_amps", &params)
+}
@@ -0,0 +1,140 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// A TokenSource supplies the Token used to authenticate requests
+// to the Tesla API.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// staticTokenSource always returns the same Token.
+type staticTokenSource struct {
+	t *Token
+}
+
+func (s *staticTokenSource) Token(ctx context.Context) (*Token, error) {
+	return s.t, nil
+}
+
+// StaticTokenSource returns a TokenSource that always returns the
+// given Token.
+func StaticTokenSource(t *Token) TokenSource {
+	return &staticTokenSource{t: t}
+}
+
+// Client is a handle for making calls to the Tesla API (and to local
+// Powerwall gateways).  All of its methods take a context.Context,
+// which can be used to cancel or time out a request.
+//
+// A Client is safe for concurrent use, provided that its underlying
+// http.Client and TokenSource are.
+type Client struct {
+	baseURL         string
+	ssoBaseURL      string
+	streamingURL    string
+	userAgent       string
+	httpClient      *http.Client
+	tokenSource     TokenSource
+	retryPolicy     RetryPolicy
+	autoWakeTimeout time.Duration
+	guard           *CommandGuard
+	passcode        PasscodeFunc
+	tokenStore      TokenStore
+	fleet           bool
+	region          Region
+	fleetClientID   string
+}
+
+// A ClientOption sets an optional parameter on a Client.
+type ClientOption func(*Client)
+
+// WithBaseURL sets the leading part of the API URL.  The default is
+// the value of BaseURL at the time the Client is created.
+func WithBaseURL(url string) ClientOption {
+	return func(c *Client) {
+		c.baseURL = url
+	}
+}
+
+// WithUserAgent sets the User-Agent passed in HTTP requests.  The
+// default is the value of UserAgent at the time the Client is created.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithHTTPClient sets the http.Client used to make requests.  The
+// default (also used if client is nil) is http.DefaultClient.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) {
+		if client != nil {
+			c.httpClient = client
+		} else {
+			c.httpClient = http.DefaultClient
+		}
+	}
+}
+
+// WithTokenSource sets the source of tokens used to authenticate
+// requests.  If no TokenSource is set, requests are not authenticated.
+func WithTokenSource(ts TokenSource) ClientOption {
+	return func(c *Client) {
+		c.tokenSource = ts
+	}
+}
+
+// WithToken authenticates requests with a fixed Token.
+func WithToken(t *Token) ClientOption {
+	return func(c *Client) {
+		if t != nil {
+			c.tokenSource = StaticTokenSource(t)
+		} else {
+			c.tokenSource = nil
+		}
+	}
+}
+
+// NewClient returns a new Client, configured with the given options.
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		baseURL:      BaseURL,
+		ssoBaseURL:   SSOBaseURL,
+		streamingURL: StreamingURL,
+		userAgent:    UserAgent,
+		httpClient:   http.DefaultClient,
+		retryPolicy:  DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// newLegacyClient makes a Client for the package-level functions
+// that take an http.Client and Token as arguments.
+func newLegacyClient(client *http.Client, token *Token) *Client {
+	return NewClient(WithHTTPClient(client), WithToken(token))
+}
+
+// token returns the Token to use for a request, or nil if requests
+// are not authenticated.
+func (c *Client) token(ctx context.Context) (*Token, error) {
+	if c.tokenSource == nil {
+		return nil, nil
+	}
+	return c.tokenSource.Token(ctx)
+}
@@ -0,0 +1,123 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"fmt"
+)
+
+//
+// Climate control commands
+//
+
+// SeatHeater identifies a seat for a remote_seat_heater_request.
+type SeatHeater int
+
+// SeatHeater values
+const (
+	SeatHeaterDriver     SeatHeater = 0
+	SeatHeaterPassenger  SeatHeater = 1
+	SeatHeaterRearLeft   SeatHeater = 2
+	SeatHeaterRearCenter SeatHeater = 4
+	SeatHeaterRearRight  SeatHeater = 5
+)
+
+// SeatHeaterLevelMax is the highest seat heater setting.  Zero turns
+// the heater off.
+const SeatHeaterLevelMax = 3
+
+// CheckTemp returns an error if a temperature setting (in Celsius) is
+// outside the range supported by the vehicle.
+func (cs *ClimateState) CheckTemp(temp float64) error {
+	if temp < cs.MinAvailTemp || temp > cs.MaxAvailTemp {
+		return fmt.Errorf("temperature %.1f out of range [%.1f, %.1f]", temp, cs.MinAvailTemp, cs.MaxAvailTemp)
+	}
+	return nil
+}
+
+// AutoConditioningStart turns on the climate control system.
+func (c *Client) AutoConditioningStart(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "auto_conditioning_start", nil)
+}
+
+// AutoConditioningStop turns off the climate control system.
+func (c *Client) AutoConditioningStop(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "auto_conditioning_stop", nil)
+}
+
+// SetTemps sets the driver and passenger temperature settings, in
+// Celsius.  The current ClimateState is retrieved first in order to
+// check the settings against the range the vehicle supports.
+func (c *Client) SetTemps(ctx context.Context, ids string, driver float64, passenger float64) (*CommandResult, error) {
+	cs, err := c.GetClimateState(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if err = cs.CheckTemp(driver); err != nil {
+		return nil, fmt.Errorf("driver %w", err)
+	}
+	if err = cs.CheckTemp(passenger); err != nil {
+		return nil, fmt.Errorf("passenger %w", err)
+	}
+
+	params := struct {
+		DriverTemp    float64 `json:"driver_temp"`
+		PassengerTemp float64 `json:"passenger_temp"`
+	}{driver, passenger}
+	return c.PostCommand(ctx, ids, "set_temps", &params)
+}
+
+// RemoteSeatHeaterRequest sets the level (0 through SeatHeaterLevelMax)
+// of a seat heater.
+func (c *Client) RemoteSeatHeaterRequest(ctx context.Context, ids string, heater SeatHeater, level int) (*CommandResult, error) {
+	switch heater {
+	case SeatHeaterDriver, SeatHeaterPassenger, SeatHeaterRearLeft,
+		SeatHeaterRearCenter, SeatHeaterRearRight:
+		/* break */
+	default:
+		return nil, fmt.Errorf("invalid seat heater %d", heater)
+	}
+	if level < 0 || level > SeatHeaterLevelMax {
+		return nil, fmt.Errorf("seat heater level %d out of range", level)
+	}
+
+	params := struct {
+		Heater SeatHeater `json:"heater"`
+		Level  int        `json:"level"`
+	}{heater, level}
+	return c.PostCommand(ctx, ids, "remote_seat_heater_request", &params)
+}
+
+// RemoteSteeringWheelHeaterRequest turns the steering wheel heater
+// on or off.
+func (c *Client) RemoteSteeringWheelHeaterRequest(ctx context.Context, ids string, on bool) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "remote_steering_wheel_heater_request", &onParams{on})
+}
+
+// SetPreconditioningMax turns "defrost mode" on or off.
+func (c *Client) SetPreconditioningMax(ctx context.Context, ids string, on bool) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "set_preconditioning_max", &onParams{on})
+}
+
+// SetCabinOverheatProtection turns cabin overheat protection on or
+// off.  If fanOnly is true, only the fan is used (no air conditioning).
+func (c *Client) SetCabinOverheatProtection(ctx context.Context, ids string, on bool, fanOnly bool) (*CommandResult, error) {
+	params := struct {
+		On      bool `json:"on"`
+		FanOnly bool `json:"fan_only"`
+	}{on, fanOnly}
+	return c.PostCommand(ctx, ids, "set_cabin_overheat_protection", &params)
+}
+
+// SetBioweaponMode turns Bioweapon Defense Mode on or off, on
+// vehicles that support it.
+func (c *Client) SetBioweaponMode(ctx context.Context, ids string, on bool) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "set_bioweapon_mode", &onParams{on})
+}
@@ -9,6 +9,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -100,14 +101,16 @@ func main() {
 
 	// Command-line arguments
 	flag.StringVar(&(gotesla.TokenCachePath), "token-cache", gotesla.TokenCachePath, "Path to Telsa token cache file")
+	account := flag.String("account", gotesla.DefaultAccount, "Account (email address or alias) in token cache")
 	verbose := flag.Bool("verbose", false, "Verbose output")
 	id := flag.String("id", "", "ID of vehicle")
+	wake := flag.Duration("wake", 0, "Wake vehicle if asleep, waiting up to this long")
 
 	// Parse command-line arguments
 	flag.Parse()
 
 	// Get cached Tesla authentication token
-	token, err := gotesla.LoadCachedToken()
+	token, err := gotesla.NewAccountTokenStore(gotesla.TokenCachePath, *account).Load()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -122,8 +125,14 @@ func main() {
 	// Make an HTTPS client
 	client := &http.Client{Transport: tr}
 
+	// Make a Tesla API client
+	tc := gotesla.NewClient(gotesla.WithHTTPClient(client),
+		gotesla.WithToken(token),
+		gotesla.WithAutoWake(*wake))
+	ctx := context.Background()
+
 	// Get vehicles list
-	vehicles, err := gotesla.GetVehicles(client, token)
+	vehicles, err := tc.GetVehicles(ctx)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -220,7 +229,7 @@ func main() {
 		}
 		fmt.Printf("vehicle_config: %+v\n", vc)
 	*/
-	mobileEnabled, err := gotesla.GetMobileEnabled(client, token, idFound)
+	mobileEnabled, err := tc.GetMobileEnabled(ctx, idFound)
 	if err != nil {
 		fmt.Printf("GetMobileEnabled: %s\n", err)
 		return
@@ -229,7 +238,7 @@ func main() {
 		fmt.Printf("mobile_enabled: %+v\n", mobileEnabled)
 	}
 
-	vehicleData, err := gotesla.GetVehicleData(client, token, idFound)
+	vehicleData, err := tc.GetVehicleData(ctx, idFound)
 	if err != nil {
 		fmt.Printf("GetVehicleData: %s\n", err)
 		return
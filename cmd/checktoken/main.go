@@ -8,21 +8,35 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/bmah888/gotesla"
 	"os"
+	"strings"
+	"time"
 )
 
 var jsonOutput = false
 
+// Revoke tokens at Tesla when clearing them
+var revoke = false
+
+// Account in the token cache
+var account = gotesla.DefaultAccount
+
+// Return the token store for the selected account
+func cache() *gotesla.AccountTokenStore {
+	return gotesla.NewAccountTokenStore(gotesla.TokenCachePath, account)
+}
+
 // Return true if the cached token is valid, false otherwise
 func checkCached() bool {
 
 	// Try to read the cached token. If it doesn't exist,
 	// clearly that's invalid.
-	t, err := gotesla.LoadCachedToken()
+	t, err := cache().Load()
 	if err != nil {
 		fmt.Println(err)
 		return false
@@ -34,7 +48,7 @@ func checkCached() bool {
 
 // Print token object in JSON representation
 func printCached() {
-	t, err := gotesla.LoadCachedToken()
+	t, err := cache().Load()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -56,9 +70,128 @@ func printCached() {
 
 // Delete the cached token
 func deleteCached() {
-	err := gotesla.DeleteCachedToken()
+	err := cache().Delete()
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Revoke the cached token at Tesla, then delete it.  Returns false
+// if any part of that failed.
+func revokeCached() bool {
+
+	// This sends live credentials, so use the default client (which
+	// verifies TLS certs)
+	tc := gotesla.NewClient()
+	err := tc.Revoke(context.Background(), cache())
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+// Print the claims in the access token
+func inspectCached() {
+	t, err := cache().Load()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	c, err := t.Claims()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if jsonOutput {
+		b, err := json.MarshalIndent(c, "", "    ")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		os.Stdout.Write(b)
+		return
+	}
+
+	fmt.Printf("Issuer:   %s\n", c.Issuer)
+	fmt.Printf("Subject:  %s\n", c.Subject)
+	fmt.Printf("Audience: %s\n", strings.Join(c.Audience, ", "))
+	fmt.Printf("Issued:   %s\n", c.Issued().Format(time.RFC3339))
+	fmt.Printf("Expires:  %s\n", c.Expires().Format(time.RFC3339))
+	fmt.Printf("Scopes:   %s\n", strings.Join(c.Scopes, " "))
+	fmt.Printf("Region:   %s\n", c.OUCode)
+}
+
+// accountInfo is the JSON representation of one cached account
+type accountInfo struct {
+	Account string    `json:"account"`
+	Alias   string    `json:"alias,omitempty"`
+	Region  string    `json:"region,omitempty"`
+	Expires time.Time `json:"expires"`
+	Valid   bool      `json:"valid"`
+	Missing bool      `json:"missing,omitempty"` // no token cached
+}
+
+// List all accounts in the token cache, with their expiry times
+func listCached() {
+	entries, err := gotesla.ListCachedAccounts(gotesla.TokenCachePath)
 	if err != nil {
 		fmt.Println(err)
+		return
+	}
+
+	var infos []accountInfo
+	for _, e := range entries {
+		if e.Token == nil {
+			infos = append(infos, accountInfo{
+				Account: e.Account,
+				Alias:   e.Alias,
+				Region:  e.Region,
+				Missing: true,
+			})
+			continue
+		}
+		_, end := gotesla.TokenTimes(e.Token)
+		infos = append(infos, accountInfo{
+			Account: e.Account,
+			Alias:   e.Alias,
+			Region:  e.Region,
+			Expires: end,
+			Valid:   gotesla.CheckToken(e.Token),
+		})
+	}
+
+	if jsonOutput {
+		b, err := json.MarshalIndent(infos, "", "    ")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		os.Stdout.Write(b)
+		return
+	}
+
+	for _, info := range infos {
+		name := info.Account
+		if name == gotesla.DefaultAccount {
+			name = "(default)"
+		}
+		if info.Alias != "" {
+			name += " (" + info.Alias + ")"
+		}
+		if info.Region != "" {
+			name += " [" + info.Region + "]"
+		}
+		if info.Missing {
+			fmt.Printf("%-40s no token\n", name)
+			continue
+		}
+		status := "valid"
+		if !info.Valid {
+			status = "expired"
+		}
+		fmt.Printf("%-40s %s  %s\n", name, info.Expires.Format(time.RFC3339), status)
 	}
 }
 
@@ -67,8 +200,10 @@ func main() {
 
 	// Command-line arguments
 	flag.StringVar(&(gotesla.TokenCachePath), "token-cache", gotesla.TokenCachePath, "Path to Telsa token cache file")
+	flag.StringVar(&account, "account", gotesla.DefaultAccount, "Account (email address or alias) in token cache")
 	flag.BoolVar(&verbose, "verbose", false, "Verbose output")
 	flag.BoolVar(&jsonOutput, "json", false, "JSON output")
+	flag.BoolVar(&revoke, "revoke", false, "Revoke token at Tesla before clearing it")
 
 	// Define new flag.Usage() so we can print the valid commands
 	flag.Usage = func() {
@@ -76,7 +211,9 @@ func main() {
 		fmt.Fprintf(flag.CommandLine.Output(), "\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  Where COMMAND is one of:\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "    check   Check stored token for validity\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "    delete  Delete stored token\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    clear   Delete stored token (and revoke it, with -revoke)\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    inspect Print claims in stored (JWT) access token\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    list    List stored accounts and token expiry times\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "    print   Print stored token\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "\n")
 		flag.PrintDefaults()
@@ -92,7 +229,7 @@ func main() {
 	}
 
 	// Commands are:
-	// check, delete, print
+	// check, clear, inspect, list, print
 	switch flag.Arg(0) {
 
 	// check
@@ -105,8 +242,26 @@ func main() {
 			}
 		}
 
+	// clear
+	// Delete the cached token, optionally revoking it first
 	case "clear":
-		deleteCached()
+		if revoke {
+			if revokeCached() == false {
+				os.Exit(1)
+			}
+		} else {
+			deleteCached()
+		}
+
+	// inspect
+	// Print the claims in the cached access token
+	case "inspect":
+		inspectCached()
+
+	// list
+	// List all accounts in the cache
+	case "list":
+		listCached()
 
 	// print
 	// Print the cached token in JSON representation
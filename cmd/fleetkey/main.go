@@ -0,0 +1,260 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/bmah888/gotesla"
+	"github.com/bmah888/gotesla/vehiclecommand"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var verbose = false
+
+// Fleet API application settings
+var domain string
+var clientID string
+var clientSecret string
+var region string
+var scopes string
+
+// loadKey reads the private key at path.  If there isn't one yet and
+// generate is true, a new one is generated (and saved); otherwise a
+// missing key is an error, since a new key can't match the one
+// already published or registered.
+func loadKey(path string, generate bool) *ecdsa.PrivateKey {
+	key, err := vehiclecommand.LoadPrivateKey(path)
+	if err == nil {
+		return key
+	}
+	if !os.IsNotExist(err) {
+		log.Fatalf("LoadPrivateKey: %v\n", err)
+	}
+	if !generate {
+		log.Fatalf("No private key in %s (use the generate command to create one)\n", path)
+	}
+	return generateKey(path)
+}
+
+// generateKey generates a new private key and saves it at path, which
+// must not already exist.
+func generateKey(path string) *ecdsa.PrivateKey {
+	_, err := os.Stat(path)
+	if err == nil {
+		log.Fatalf("Private key %s already exists\n", path)
+	}
+	if !os.IsNotExist(err) {
+		log.Fatalf("Stat: %v\n", err)
+	}
+
+	key, err := vehiclecommand.GeneratePrivateKey()
+	if err != nil {
+		log.Fatalf("GeneratePrivateKey: %v\n", err)
+	}
+	err = vehiclecommand.SavePrivateKey(path, key)
+	if err != nil {
+		log.Fatalf("SavePrivateKey: %v\n", err)
+	}
+	if verbose {
+		fmt.Printf("Generated new key in %s\n", path)
+	}
+	return key
+}
+
+// publicKeyPEM returns the PEM form of a key's public key.
+func publicKeyPEM(key *ecdsa.PrivateKey) []byte {
+	pem, err := vehiclecommand.PublicKeyPEM(&key.PublicKey)
+	if err != nil {
+		log.Fatalf("PublicKeyPEM: %v\n", err)
+	}
+	return pem
+}
+
+// serve publishes the public key at the well-known path.
+func serve(key *ecdsa.PrivateKey, listen string, certFile string, keyFile string) {
+	pem := publicKeyPEM(key)
+	http.HandleFunc(gotesla.PublicKeyPath, func(w http.ResponseWriter, r *http.Request) {
+		if verbose {
+			fmt.Printf("%s %s from %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+		}
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(pem)
+	})
+
+	if verbose {
+		fmt.Printf("Serving %s on %s\n", gotesla.PublicKeyPath, listen)
+	}
+	var err error
+	if certFile != "" {
+		err = http.ListenAndServeTLS(listen, certFile, keyFile, nil)
+	} else {
+		err = http.ListenAndServe(listen, nil)
+	}
+	log.Fatalf("ListenAndServe: %v\n", err)
+}
+
+// verify checks that the key published at the domain is ours.
+func verify(ctx context.Context, key *ecdsa.PrivateKey) bool {
+	pub, err := vehiclecommand.FetchPublicKey(ctx, nil, domain)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	if pub.X.Cmp(key.PublicKey.X) != 0 || pub.Y.Cmp(key.PublicKey.Y) != 0 {
+		fmt.Printf("Key published at %s does not match local private key\n", domain)
+		return false
+	}
+	if verbose {
+		fmt.Printf("Key published at %s matches local private key\n", domain)
+	}
+	return true
+}
+
+// register registers the domain with Tesla, and checks that Tesla
+// has our public key on file.
+func register(ctx context.Context, key *ecdsa.PrivateKey) bool {
+	if clientID == "" || clientSecret == "" {
+		fmt.Println("Need -client-id and -client-secret (or TESLA_CLIENT_SECRET)")
+		return false
+	}
+
+	// Check the published key first, since registration will fail
+	// without it
+	if !verify(ctx, key) {
+		return false
+	}
+
+	tc := gotesla.NewClient(gotesla.WithFleetAPI(gotesla.Region(region)))
+	t, err := tc.GetPartnerToken(ctx, clientID, clientSecret, strings.Fields(scopes))
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	tc = gotesla.NewClient(gotesla.WithFleetAPI(gotesla.Region(region)), gotesla.WithToken(t))
+
+	account, err := tc.RegisterPartner(ctx, domain)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	if verbose {
+		fmt.Printf("Registered %s (client ID %s)\n", account.Domain, account.ClientID)
+	}
+
+	registered, err := tc.GetPartnerPublicKey(ctx, domain)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	local := hex.EncodeToString(vehiclecommand.PublicKeyBytes(&key.PublicKey))
+	if !strings.EqualFold(registered, local) {
+		fmt.Printf("Key registered for %s does not match local private key\n", domain)
+		return false
+	}
+	if verbose {
+		fmt.Printf("Key registered for %s matches local private key\n", domain)
+	}
+	return true
+}
+
+func main() {
+	// Command-line arguments
+	keyPath := flag.String("key", "fleet-key.pem", "Path to EC private key file")
+	generate := flag.Bool("generate", false, "Generate a key for serve if there isn't one")
+	listen := flag.String("listen", ":8080", "Address to serve the public key on")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file for serving (default plain HTTP)")
+	tlsKey := flag.String("tls-key", "", "TLS private key file for serving")
+	flag.StringVar(&domain, "domain", "", "Application domain")
+	flag.StringVar(&clientID, "client-id", "", "Fleet API application client ID")
+	flag.StringVar(&clientSecret, "client-secret", os.Getenv("TESLA_CLIENT_SECRET"), "Fleet API application client secret")
+	flag.StringVar(&region, "region", string(gotesla.RegionNA), "Fleet API region (na, eu, cn)")
+	flag.StringVar(&scopes, "scopes", "openid vehicle_device_data vehicle_cmds vehicle_charging_cmds", "Partner token scopes")
+	flag.BoolVar(&verbose, "verbose", false, "Verbose output")
+
+	// Define new flag.Usage() so we can print the valid commands
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s [flags] COMMAND:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  Where COMMAND is one of:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    generate Generate new key pair\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    pubkey   Print public key\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    register Register domain with Tesla and check its key\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    serve    Serve public key at %s\n", gotesla.PublicKeyPath)
+		fmt.Fprintf(flag.CommandLine.Output(), "    verify   Check key published at domain\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		flag.PrintDefaults()
+	}
+
+	// Parse command-line arguments
+	flag.Parse()
+
+	// We need exactly one word after any arguments...it's a command
+	if flag.NArg() != 1 {
+		fmt.Println("Need exactly one command")
+		return
+	}
+	if _, ok := gotesla.FleetBaseURLs[gotesla.Region(region)]; !ok {
+		log.Fatalf("Unknown region %q\n", region)
+	}
+	if (flag.Arg(0) == "register" || flag.Arg(0) == "verify") && domain == "" {
+		log.Fatalf("Need -domain\n")
+	}
+
+	ctx := context.Background()
+
+	// Commands are:
+	// generate, pubkey, register, serve, verify
+	switch flag.Arg(0) {
+
+	// generate
+	// Generate a new key pair, and print the public key
+	case "generate":
+		key := generateKey(*keyPath)
+		os.Stdout.Write(publicKeyPEM(key))
+
+	// pubkey
+	// Print the public key, in the form it is published
+	case "pubkey":
+		key := loadKey(*keyPath, false)
+		os.Stdout.Write(publicKeyPEM(key))
+
+	// register
+	// Register the application's domain, and check the registered key
+	case "register":
+		key := loadKey(*keyPath, false)
+		if !register(ctx, key) {
+			os.Exit(1)
+		}
+
+	// serve
+	// Serve the public key at the well-known path
+	case "serve":
+		key := loadKey(*keyPath, *generate)
+		serve(key, *listen, *tlsCert, *tlsKey)
+
+	// verify
+	// Check that the key published at the domain matches ours
+	case "verify":
+		key := loadKey(*keyPath, false)
+		if !verify(ctx, key) {
+			os.Exit(1)
+		}
+
+	default:
+		fmt.Println("Invalid command")
+
+	}
+}
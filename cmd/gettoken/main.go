@@ -8,6 +8,8 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"flag"
@@ -15,15 +17,29 @@ import (
 	"github.com/bmah888/gotesla"
 	"net/http"
 	"os"
+	"strings"
 )
 
+// promptPasscode asks the user for an MFA passcode.
+func promptPasscode(ctx context.Context) (string, error) {
+	fmt.Fprint(os.Stderr, "MFA passcode: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
 func main() {
 	var verbose = false
 
 	// Command-line arguments
 	var email = flag.String("email", "", "MyTesla email address")
 	var password = flag.String("password", "", "MyTesla account password")
+	var passcode = flag.String("passcode", "", "MFA passcode (prompted for if needed and not given)")
 	var refresh = flag.Bool("refresh", false, "Refresh existing cached token")
+	var account = flag.String("account", gotesla.DefaultAccount, "Account in token cache (alias for -email, or email address or alias for -refresh)")
+	var region = flag.String("region", "", "Fleet API region of account in token cache (na, eu, cn; default from token)")
 	flag.StringVar(&(gotesla.TokenCachePath), "token-cache", gotesla.TokenCachePath, "Path to Telsa token cache file")
 	var jsonOutput = flag.Bool("json", false, "Print token JSON")
 	flag.BoolVar(&verbose, "verbose", false, "Verbose output")
@@ -40,6 +56,37 @@ func main() {
 	// Make an HTTPS client
 	client := &http.Client{Transport: tr}
 
+	// Get an MFA passcode from the command line, or ask for one
+	pf := promptPasscode
+	if len(*passcode) > 0 {
+		pf = func(ctx context.Context) (string, error) {
+			return *passcode, nil
+		}
+	}
+	// Cache a new token under the email address, with the account
+	// name (if different) as an alias, and the region (if not given)
+	// taken from the token.  When refreshing, look up the account by
+	// email address or alias, and region if given.
+	if *region != "" {
+		if _, ok := gotesla.FleetBaseURLs[gotesla.Region(*region)]; !ok {
+			fmt.Printf("Unknown region %q\n", *region)
+			return
+		}
+	}
+	store := gotesla.NewAccountTokenStore(gotesla.TokenCachePath, *account)
+	store.Region = *region
+	if !*refresh && len(*account) > 0 {
+		store.Account = *email
+		if *account != *email {
+			store.Alias = *account
+		}
+	}
+
+	tc := gotesla.NewClient(gotesla.WithHTTPClient(client),
+		gotesla.WithPasscodeFunc(pf),
+		gotesla.WithTokenStore(store))
+	ctx := context.Background()
+
 	var t *gotesla.Token
 	var err error
 
@@ -47,12 +94,12 @@ func main() {
 	// we're doing a fresh login and we need a username and password
 	if *refresh {
 		var t0 *gotesla.Token
-		t0, err = gotesla.LoadCachedToken()
+		t0, err = store.Load()
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
-		t, err = gotesla.RefreshAndCacheToken(client, t0)
+		t, err = tc.RefreshAndCacheToken(ctx, t0)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -60,7 +107,7 @@ func main() {
 	} else if len(*email) > 0 && len(*password) > 0 {
 
 		// Get an authentication token
-		t, err = gotesla.GetAndCacheToken(client, email, password)
+		t, err = tc.GetAndCacheToken(ctx, email, password)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -9,6 +9,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -46,17 +47,11 @@ func main() {
 	flag.BoolVar(&verbose, "verbose", false, "Verbose output")
 
 	flag.StringVar(&(gotesla.TokenCachePath), "token-cache", gotesla.TokenCachePath, "Path to Telsa token cache file")
+	account := flag.String("account", gotesla.DefaultAccount, "Account (email address or alias) in token cache")
 
 	// Parse command-line arguments
 	flag.Parse()
 
-	// Get cached Tesla authentication token
-	token, err := gotesla.LoadCachedToken()
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-
 	// Don't verify TLS certs...
 	tls := &tls.Config{InsecureSkipVerify: true}
 
@@ -66,8 +61,14 @@ func main() {
 	// Make an HTTPS client
 	client := &http.Client{Transport: tr}
 
+	// Use the cached Tesla authentication token, refreshing it
+	// (and updating the cache) as needed
+	tc := gotesla.NewClient(gotesla.WithHTTPClient(client),
+		gotesla.WithAutoRefresh(gotesla.NewAccountTokenStore(gotesla.TokenCachePath, *account)))
+	ctx := context.Background()
+
 	// Get vehicles list
-	vehicles, err := gotesla.GetVehicles(client, token)
+	vehicles, err := tc.GetVehicles(ctx)
 	if err != nil {
 		log.Fatalf("GetVehicles: %v\n", err)
 		return
@@ -88,31 +89,21 @@ func main() {
 
 	for {
 
-		// See if token will be expiring soon (less than a day)
-		h := gotesla.TokenLifetime(token).Hours()
-		if verbose {
-			fmt.Printf("Token lifetime (hours): %f\n", h)
-		}
-		if h < 24.0 {
-			// Expiring soon, attempt to refresh it.
-			token2, err := gotesla.RefreshAndCacheToken(client, token)
-			if err != nil {
-				log.Printf("RefreshAndCacheToken: %v\n", err)
-			} else {
-				token = token2
-				if verbose {
-					log.Printf("Refresh token successful\n")
-				}
-			}
-		}
-
 		for _, v := range *vehicles {
 			if verbose {
 				fmt.Printf("Vehicle: id %s VIN %s\n", v.IDS, v.Vin)
 			}
 
-			nc, err := gotesla.GetNearbyChargers(client, token, v.IDS)
+			nc, err := tc.GetNearbyChargers(ctx, v.IDS)
 			if err != nil {
+				// A sleeping car is normal and not worth logging
+				// unless we're being verbose.
+				if gotesla.IsVehicleAsleep(err) {
+					if verbose {
+						fmt.Printf("Vehicle %s is asleep\n", v.IDS)
+					}
+					continue
+				}
 				log.Printf("GetNearbyChargers: %v\n", err)
 				continue
 			}
@@ -0,0 +1,126 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/bmah888/gotesla/telemetry"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	influxClient "github.com/influxdata/influxdb1-client/v2" // too many things called "client"
+)
+
+// InfluxURL is the URL to the InfluxDB server
+var InfluxURL string
+
+// InfluxDb is the database name
+var InfluxDb string
+
+// InfluxMeasurement is the name of the InfluxDB measurement
+var InfluxMeasurement string
+
+func main() {
+	var verbose = false
+
+	// Command-line arguments
+	listen := flag.String("listen", ":4443", "Address to listen on for vehicle connections")
+	certFile := flag.String("cert", "server.crt", "Server TLS certificate file")
+	keyFile := flag.String("key", "server.key", "Server TLS private key file")
+	caFile := flag.String("ca", "vehicle-ca.crt", "CA certificates for vehicle client certificates")
+	flag.StringVar(&InfluxURL, "influx-url", "http://localhost:8086",
+		"Influx database server URL")
+	flag.StringVar(&InfluxDb, "influx-database", "tesla",
+		"Influx database name")
+	flag.StringVar(&InfluxMeasurement, "influx-measurement", "telemetry",
+		"Influx measurement name")
+	flag.BoolVar(&verbose, "verbose", false, "Verbose output")
+
+	// Parse command-line arguments
+	flag.Parse()
+
+	tlsConfig, err := telemetry.NewTLSConfig(*certFile, *keyFile, *caFile)
+	if err != nil {
+		log.Fatalf("NewTLSConfig: %v\n", err)
+	}
+
+	// Make an InfluxDB client
+	dbClient, err := influxClient.NewHTTPClient(influxClient.HTTPConfig{
+		Addr: InfluxURL,
+	})
+	if err != nil {
+		log.Fatalf("NewHTTPClient: %v\n", err)
+	}
+	defer dbClient.Close()
+
+	sinks := []telemetry.Sink{
+		&telemetry.InfluxSink{
+			Client:      dbClient,
+			Database:    InfluxDb,
+			Measurement: InfluxMeasurement,
+		},
+	}
+	if verbose {
+		sinks = append(sinks, telemetry.SinkFunc(printRecord))
+	}
+	fanout := telemetry.NewFanout(telemetry.DefaultFanoutBuffer, nil, sinks...)
+
+	receiver := &telemetry.Server{Sink: fanout}
+	server := &http.Server{
+		Addr:      *listen,
+		Handler:   receiver,
+		TLSConfig: tlsConfig,
+	}
+
+	// Shut down cleanly on SIGINT or SIGTERM, writing out any
+	// queued records
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+		receiver.Close()
+		close(done)
+	}()
+
+	if verbose {
+		fmt.Printf("Listening on %s\n", *listen)
+	}
+	err = server.ListenAndServeTLS("", "")
+	if err != http.ErrServerClosed {
+		log.Fatalf("ListenAndServeTLS: %v\n", err)
+	}
+	<-done
+	fanout.Close()
+	if n := fanout.Dropped(); n > 0 {
+		log.Printf("%d records refused with sink queues full\n", n)
+	}
+}
+
+// printRecord prints a telemetry record.
+func printRecord(ctx context.Context, r *telemetry.Record) error {
+	fmt.Printf("%s %s", r.CreatedAt.Format(time.RFC3339), r.VIN)
+	if r.Resend {
+		fmt.Printf(" (resend)")
+	}
+	fmt.Printf("\n")
+	for _, f := range r.Fields() {
+		fmt.Printf("  %s: %s\n", f, r.Data[f])
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+//
+// Vehicle commands
+//
+
+// CommandResponse is the response to a vehicle command.
+type CommandResponse struct {
+	Response CommandResult `json:"response"`
+}
+
+// CommandResult is the result of a vehicle command.  Result is true
+// if the vehicle accepted the command; if not, Reason usually says why.
+// Command is filled in locally with the name of the command.
+type CommandResult struct {
+	Result  bool   `json:"result"`
+	Reason  string `json:"reason"`
+	Command string `json:"-"`
+}
+
+// ErrNotSupported is returned (usually wrapped in a more specific
+// error) when a vehicle's state or configuration shows that it can't
+// carry out a command.
+var ErrNotSupported = errors.New("not supported by vehicle")
+
+// onParams are the parameters for the many commands that just turn
+// something on or off.
+type onParams struct {
+	On bool `json:"on"`
+}
+
+// PostCommand sends a command to a vehicle.  The params (if non-nil)
+// are marshalled to JSON and passed as the body of the request.
+// If the Client has a CommandGuard, it decides whether the command
+// is actually sent.  Most callers will want to use one of the methods
+// for a specific command instead.
+func (c *Client) PostCommand(ctx context.Context, ids string, command string, params interface{}) (*CommandResult, error) {
+	var verbose = false
+
+	payload := []byte("{}")
+	if params != nil {
+		var err error
+		payload, err = json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Don't print the payload, it might contain a PIN
+	if verbose {
+		fmt.Printf("Command %s\n", command)
+	}
+
+	return c.sendCommand(ctx, ids, command, payload)
+}
+
+// sendCommand sends a command with an already-marshalled payload,
+// subject to the Client's CommandGuard (if any).
+func (c *Client) sendCommand(ctx context.Context, ids string, command string, payload []byte) (*CommandResult, error) {
+	return c.GuardCommand(ids, command, payload, func() (*CommandResult, error) {
+		return c.postCommandPayload(ctx, ids, command, payload)
+	})
+}
+
+// GuardCommand checks a command that is sent some other way than
+// PostCommand (for example, a signed command; see the vehiclecommand
+// package) with the Client's CommandGuard, if it has one.  The payload
+// is the command's parameters as JSON (or nil), for the audit log.
+// send is called if the command should actually be sent.
+func (c *Client) GuardCommand(ids string, command string, payload []byte, send func() (*CommandResult, error)) (*CommandResult, error) {
+	if c.guard != nil {
+		return c.guard.guardCommand(ids, command, payload, send)
+	}
+	return send()
+}
+
+// postCommandPayload sends a command with an already-marshalled payload.
+func (c *Client) postCommandPayload(ctx context.Context, ids string, command string, payload []byte) (*CommandResult, error) {
+	var verbose = false
+	var cr CommandResponse
+
+	body, err := c.PostTesla(ctx, "/api/1/vehicles/"+ids+"/command/"+command, payload)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		fmt.Printf("Response: %s\n", body)
+	}
+
+	err = json.Unmarshal(body, &cr)
+	if err != nil {
+		return nil, err
+	}
+	cr.Response.Command = command
+	return &(cr.Response), nil
+}
@@ -0,0 +1,118 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// An APIError is returned when the Tesla API or a Powerwall gateway
+// answers a request with an HTTP status other than 200 OK.
+type APIError struct {
+	StatusCode       int           // HTTP status code
+	Method           string        // HTTP request method
+	Endpoint         string        // API endpoint (path) of the request
+	Body             []byte        // Raw response body
+	ErrorCode        string        // "error" from a JSON response body, if any
+	ErrorDescription string        // "error_description" from a JSON response body, if any
+	RetryAfter       time.Duration // From the Retry-After header, zero if absent
+}
+
+// Error returns a string representation of an APIError.
+func (e *APIError) Error() string {
+	s := fmt.Sprintf("%s %s: %d %s", e.Method, e.Endpoint, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.ErrorCode != "" {
+		s += ": " + e.ErrorCode
+	}
+	if e.ErrorDescription != "" {
+		s += " (" + e.ErrorDescription + ")"
+	}
+	return s
+}
+
+// newAPIError builds an APIError from an HTTP response and the body
+// that was read from it.
+func newAPIError(resp *http.Response, method string, endpoint string, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     method,
+		Endpoint:   endpoint,
+		Body:       body,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+
+	// The Tesla API usually (but not always) returns a JSON body
+	// describing the error.  If we can't parse it, there's still
+	// the raw body.
+	var eb struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &eb) == nil {
+		e.ErrorCode = eb.Error
+		e.ErrorDescription = eb.ErrorDescription
+	}
+
+	return e
+}
+
+// parseRetryAfter interprets the value of a Retry-After header, which
+// is either a number of seconds or an HTTP date.  Returns zero if the
+// value is absent or can't be parsed.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// statusCode returns the HTTP status code of an APIError contained
+// in err, or zero if there isn't one.
+func statusCode(err error) int {
+	var e *APIError
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsUnauthorized returns true if err indicates that the request was
+// not authorized, usually due to an invalid or expired token.
+func IsUnauthorized(err error) bool {
+	return statusCode(err) == http.StatusUnauthorized
+}
+
+// IsVehicleAsleep returns true if err indicates that the vehicle was
+// asleep or otherwise unavailable.  The Tesla API signals this with
+// a 408 status.
+func IsVehicleAsleep(err error) bool {
+	return statusCode(err) == http.StatusRequestTimeout
+}
+
+// IsRateLimited returns true if err indicates that the request was
+// rejected due to rate limiting.
+func IsRateLimited(err error) bool {
+	return statusCode(err) == http.StatusTooManyRequests
+}
@@ -0,0 +1,359 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//
+// Tesla Fleet API
+//
+// The Fleet API is the successor to the owner API.  It is served from
+// regional hosts, and shares most of the owner API's /api/1 endpoints
+// for vehicle data and commands.  The per-state data_request calls
+// (GetChargeState and so on) don't exist in the Fleet API; in Fleet
+// API mode a Client makes them with vehicle_data instead.  Tokens are
+// refreshed with Tesla SSO rather than the owner API, and the owner
+// API's streaming websocket isn't available (see the telemetry package
+// instead).  Applications need to be registered with Tesla as
+// partners; partner calls are authenticated with a partner token
+// rather than a user's token.
+//
+
+// A Region is a Tesla Fleet API region.
+type Region string
+
+// Region values.  RegionAuto selects the region based on the user's
+// access token.
+const (
+	RegionAuto Region = ""
+	RegionNA   Region = "na" // North America and Asia-Pacific (other than China)
+	RegionEU   Region = "eu" // Europe, Middle East and Africa
+	RegionCN   Region = "cn" // China
+)
+
+// FleetBaseURLs are the leading parts of the Fleet API URLs, by region.
+var FleetBaseURLs = map[Region]string{
+	RegionNA: "https://fleet-api.prd.na.vn.cloud.tesla.com",
+	RegionEU: "https://fleet-api.prd.eu.vn.cloud.tesla.com",
+	RegionCN: "https://fleet-api.prd.cn.vn.cloud.tesla.cn",
+}
+
+// ErrUnknownRegion is returned by requests made by a Client whose
+// Fleet API region isn't in FleetBaseURLs.
+var ErrUnknownRegion = errors.New("unknown Fleet API region")
+
+// RegionFromOUCode returns the Fleet API region for the ou_code claim
+// in an access token.
+func RegionFromOUCode(ouCode string) (Region, error) {
+	switch strings.ToUpper(ouCode) {
+	case "NA", "AP":
+		return RegionNA, nil
+	case "EU":
+		return RegionEU, nil
+	case "CN":
+		return RegionCN, nil
+	}
+	return RegionAuto, fmt.Errorf("unknown ou_code %q", ouCode)
+}
+
+// TokenRegion returns the Fleet API region for a Token, based on the
+// claims in its access token.
+func TokenRegion(t *Token) (Region, error) {
+	c, err := t.Claims()
+	if err != nil {
+		return RegionAuto, err
+	}
+	return RegionFromOUCode(c.OUCode)
+}
+
+// WithFleetAPI makes a Client use the Fleet API in the given region,
+// instead of the owner API.  With RegionAuto, the region of each
+// request is chosen from the claims in its access token, defaulting to
+// RegionNA.  This overrides WithBaseURL.  If the region isn't in
+// FleetBaseURLs, the Client's requests fail with ErrUnknownRegion.
+func WithFleetAPI(region Region) ClientOption {
+	return func(c *Client) {
+		c.fleet = true
+		c.region = region
+	}
+}
+
+// WithFleetClientID sets the client ID that tokens are refreshed with
+// in Fleet API mode:  that of the application the user authorized.
+// The default is the SSO client ID used by GetToken.
+func WithFleetClientID(clientID string) ClientOption {
+	return func(c *Client) {
+		c.fleetClientID = clientID
+	}
+}
+
+// fleetBaseURL returns the leading part of the Fleet API URL for a
+// region.
+func fleetBaseURL(region Region) (string, error) {
+	base, ok := FleetBaseURLs[region]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownRegion, region)
+	}
+	return base, nil
+}
+
+// apiBaseURL returns the leading part of the URL for a request
+// authenticated with the given token (which may be nil).
+func (c *Client) apiBaseURL(token *Token) (string, error) {
+	if !c.fleet {
+		return c.baseURL, nil
+	}
+	region := c.region
+	if region == RegionAuto {
+		region = RegionNA
+		if token != nil {
+			if r, err := TokenRegion(token); err == nil {
+				region = r
+			}
+		}
+	}
+	return fleetBaseURL(region)
+}
+
+// ownerAPI returns a Client for calls that only exist in the owner
+// API (such as exchanging an SSO token for an owner API token), even
+// in Fleet API mode.
+func (c *Client) ownerAPI() *Client {
+	if !c.fleet {
+		return c
+	}
+	oc := *c
+	oc.fleet = false
+	return &oc
+}
+
+// refreshTokenFleet refreshes a Token with Tesla SSO, as the Fleet API
+// requires.  Unlike refreshTokenSSO, the new SSO access token is used
+// as is, rather than exchanged for an owner API token.
+func (c *Client) refreshTokenFleet(ctx context.Context, token *Token) (*Token, error) {
+	refresh := token.SSORefreshToken
+	if refresh == "" {
+		refresh = token.RefreshToken
+	}
+	clientID := c.fleetClientID
+	if clientID == "" {
+		clientID = ssoClientID
+	}
+
+	s, err := c.newSSOSession(url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("client_id", clientID)
+	form.Set("refresh_token", refresh)
+	resp, body, err := s.do(ctx, "POST", c.ssoBaseURL+"/oauth2/v3/token", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp, "POST", "/oauth2/v3/token", body)
+	}
+
+	var st SSOToken
+	err = json.Unmarshal(body, &st)
+	if err != nil {
+		return nil, err
+	}
+	if st.RefreshToken == "" {
+		st.RefreshToken = refresh
+	}
+	t := Token{
+		AccessToken:  st.AccessToken,
+		TokenType:    st.TokenType,
+		ExpiresIn:    st.ExpiresIn,
+		RefreshToken: st.RefreshToken,
+		CreatedAt:    int(time.Now().Unix()),
+	}
+	if token.SSORefreshToken != "" {
+		t.SSORefreshToken = st.RefreshToken
+	}
+	return &t, nil
+}
+
+// fleetDataRequest maps an owner API data_request endpoint (such as
+// /api/1/vehicles/{id}/data_request/charge_state) to the state it
+// returns.
+func fleetDataRequest(endpoint string) (state string, ok bool) {
+	i := strings.LastIndex(endpoint, "/data_request/")
+	if i < 0 {
+		return "", false
+	}
+	return endpoint[i+len("/data_request/"):], true
+}
+
+// getFleetState gets one part of a vehicle's state (such as
+// charge_state) with a vehicle_data request, and returns it in the
+// form of the owner API's data_request response.
+func (c *Client) getFleetState(ctx context.Context, ids string, state string) ([]byte, error) {
+	var resp struct {
+		Response map[string]json.RawMessage `json:"response"`
+	}
+
+	endpoints := state
+	if state == "drive_state" {
+		// Newer firmware only reports location if asked
+		endpoints += ";location_data"
+	}
+	body, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/vehicle_data?endpoints="+url.QueryEscape(endpoints))
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := resp.Response[state]
+	if !ok {
+		return nil, fmt.Errorf("vehicle_data response has no %s", state)
+	}
+	return json.Marshal(map[string]json.RawMessage{"response": raw})
+}
+
+// UserRegion is the response to a user region request.
+type UserRegion struct {
+	Region          string `json:"region"`
+	FleetAPIBaseURL string `json:"fleet_api_base_url"`
+}
+
+// GetUserRegion asks the Fleet API which region a user's account is in.
+func (c *Client) GetUserRegion(ctx context.Context) (*UserRegion, error) {
+	var resp struct {
+		Response UserRegion `json:"response"`
+	}
+	body, err := c.GetTesla(ctx, "/api/1/users/region")
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Response, nil
+}
+
+// GetPartnerToken gets a partner token with the OAuth2 client
+// credentials grant, using the client ID and secret of an application
+// registered with Tesla.  The token is good for the Fleet API region
+// the Client uses (RegionNA if RegionAuto).  Partner tokens can't be
+// refreshed; get a new one instead.  For RegionCN, set the SSO URL
+// to the Chinese SSO server with WithSSOBaseURL.
+func (c *Client) GetPartnerToken(ctx context.Context, clientID string, clientSecret string, scopes []string) (*Token, error) {
+	region := c.region
+	if region == RegionAuto {
+		region = RegionNA
+	}
+	audience, err := fleetBaseURL(region)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := c.newSSOSession(url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", clientID)
+	form.Set("client_secret", clientSecret)
+	form.Set("scope", strings.Join(scopes, " "))
+	form.Set("audience", audience)
+	resp, body, err := s.do(ctx, "POST", c.ssoBaseURL+"/oauth2/v3/token", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp, "POST", "/oauth2/v3/token", body)
+	}
+
+	var t Token
+	err = json.Unmarshal(body, &t)
+	if err != nil {
+		return nil, err
+	}
+	if t.CreatedAt == 0 {
+		t.CreatedAt = int(time.Now().Unix())
+	}
+	return &t, nil
+}
+
+// PartnerAccount describes an application registered with Tesla.
+type PartnerAccount struct {
+	AccountID   string `json:"account_id"`
+	ClientID    string `json:"client_id"`
+	Domain      string `json:"domain"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	PublicKey   string `json:"public_key"` // hex-encoded EC point
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// RegisterPartner registers an application's domain in the Client's
+// Fleet API region.  The Client must be authenticated with a partner
+// token (see GetPartnerToken), and the domain must serve the
+// application's public key at PublicKeyPath.
+func (c *Client) RegisterPartner(ctx context.Context, domain string) (*PartnerAccount, error) {
+	var resp struct {
+		Response PartnerAccount `json:"response"`
+	}
+
+	payload, err := json.Marshal(map[string]string{"domain": domain})
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.PostTesla(ctx, "/api/1/partner_accounts", payload)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Response, nil
+}
+
+// GetPartnerPublicKey returns the public key that Tesla has on file
+// for a registered domain, as a hex-encoded EC point.  The Client must
+// be authenticated with a partner token.
+func (c *Client) GetPartnerPublicKey(ctx context.Context, domain string) (string, error) {
+	var resp struct {
+		Response struct {
+			PublicKey string `json:"public_key"`
+		} `json:"response"`
+	}
+
+	body, err := c.GetTesla(ctx, "/api/1/partner_accounts/public_key?domain="+url.QueryEscape(domain))
+	if err != nil {
+		return "", err
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return "", err
+	}
+	return resp.Response.PublicKey, nil
+}
+
+// PublicKeyPath is where a partner's domain must serve its public key.
+const PublicKeyPath = "/.well-known/appspecific/com.tesla.3p.public-key.pem"
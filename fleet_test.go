@@ -0,0 +1,97 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// withFleetServer makes a fake Fleet API region served by s, and
+// returns a function that removes it.
+func withFleetServer(s *httptest.Server) (Region, func()) {
+	const region Region = "test"
+	FleetBaseURLs[region] = s.URL
+	return region, func() { delete(FleetBaseURLs, region) }
+}
+
+func TestFleetUnknownRegion(t *testing.T) {
+	c := NewClient(WithFleetAPI("xx"), WithRetryPolicy(NoRetryPolicy))
+	_, err := c.GetTesla(context.Background(), "/api/1/vehicles")
+	if !errors.Is(err, ErrUnknownRegion) {
+		t.Errorf("GetTesla: got %v, want ErrUnknownRegion", err)
+	}
+	_, err = c.GetPartnerToken(context.Background(), "id", "secret", nil)
+	if !errors.Is(err, ErrUnknownRegion) {
+		t.Errorf("GetPartnerToken: got %v, want ErrUnknownRegion", err)
+	}
+}
+
+func TestFleetDataRequest(t *testing.T) {
+	var query url.Values
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/1/vehicles/1234/vehicle_data" {
+			http.NotFound(w, r)
+			return
+		}
+		query = r.URL.Query()
+		w.Write([]byte(`{"response":{"id_s":"1234","charge_state":{"battery_level":77}}}`))
+	}))
+	defer s.Close()
+	region, cleanup := withFleetServer(s)
+	defer cleanup()
+
+	c := NewClient(WithFleetAPI(region), WithRetryPolicy(NoRetryPolicy))
+	cs, err := c.GetChargeState(context.Background(), "1234")
+	if err != nil {
+		t.Fatalf("GetChargeState: %v", err)
+	}
+	if cs.BatteryLevel != 77 {
+		t.Errorf("got battery level %d, want 77", cs.BatteryLevel)
+	}
+	if query.Get("endpoints") != "charge_state" {
+		t.Errorf("requested endpoints %q", query.Get("endpoints"))
+	}
+
+	_, err = c.GetClimateState(context.Background(), "1234")
+	if err == nil {
+		t.Error("GetClimateState succeeded without climate_state in response")
+	}
+}
+
+func TestFleetRefreshToken(t *testing.T) {
+	var form url.Values
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth2/v3/token" {
+			http.NotFound(w, r)
+			return
+		}
+		r.ParseForm()
+		form = r.PostForm
+		fmt.Fprint(w, `{"access_token":"fleet-access-2","refresh_token":"fleet-refresh-2","expires_in":28800,"token_type":"Bearer"}`)
+	}))
+	defer s.Close()
+
+	c := NewClient(WithFleetAPI(RegionNA), WithSSOBaseURL(s.URL), WithFleetClientID("my-app"), WithRetryPolicy(NoRetryPolicy))
+	tok, err := c.RefreshToken(context.Background(), &Token{AccessToken: "fleet-access-1", RefreshToken: "fleet-refresh-1"})
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	if form.Get("grant_type") != "refresh_token" || form.Get("client_id") != "my-app" || form.Get("refresh_token") != "fleet-refresh-1" {
+		t.Errorf("got refresh request %v", form)
+	}
+	if tok.AccessToken != "fleet-access-2" || tok.RefreshToken != "fleet-refresh-2" || tok.SSORefreshToken != "" {
+		t.Errorf("got token %+v", tok)
+	}
+}
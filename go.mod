@@ -3,6 +3,8 @@ module github.com/bmah888/gotesla
 go 1.13
 
 require (
+	github.com/gorilla/websocket v1.4.2
 	github.com/influxdata/influxdb1-client v0.0.0-20200515024757-02f0bf5dbca3
-	google.golang.org/protobuf v1.27.1 // indirect
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	google.golang.org/protobuf v1.27.1
 )
@@ -24,9 +24,10 @@ package gotesla
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"os"
 	"time"
@@ -34,7 +35,8 @@ import (
 
 // Tesla API parameters
 
-// BaseURL is the leading part of the API URL.  It is unlikely to ever change.
+// BaseURL is the leading part of the owner API URL.  (See also
+// WithFleetAPI.)
 var BaseURL = "https://owner-api.teslamotors.com"
 
 // UserAgent is passed in HTTP requests to the Tesla API.
@@ -74,37 +76,44 @@ type Auth struct {
 }
 
 // Token is basically an OAUTH 2.0 bearer token plus some metadata.
+// Tokens obtained through Tesla SSO also carry the SSO refresh token,
+// which is used to refresh them.
 type Token struct {
-	AccessToken  string `json:"access_token"`
-	TokenType    string `json:"token_type"`
-	ExpiresIn    int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token"`
-	CreatedAt    int    `json:"created_at"`
+	AccessToken     string `json:"access_token"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token"`
+	CreatedAt       int    `json:"created_at"`
+	SSORefreshToken string `json:"sso_refresh_token,omitempty"`
 }
 
 //
 // GetToken authenticates with Tesla servers and returns a Token
-// structure.
+// structure.  It logs in through Tesla SSO; if the account has MFA
+// enabled, the Client needs a PasscodeFunc (see WithPasscodeFunc).
 //
-func GetToken(client *http.Client, username *string, password *string) (*Token, error) {
-
-	// Create JSON structure for authentication request
-	var auth Auth
-	auth.GrantType = "password"
-	auth.ClientID = teslaClientID
-	auth.ClientSecret = teslaClientSecret
-	auth.Email = *username
-	auth.Password = *password
+func (c *Client) GetToken(ctx context.Context, username *string, password *string) (*Token, error) {
+	return c.ssoLogin(ctx, *username, *password)
+}
 
-	// call common code
-	return tokenAuthCommon(client, &auth)
+// GetToken is the package-level equivalent of Client.GetToken.
+func GetToken(client *http.Client, username *string, password *string) (*Token, error) {
+	return newLegacyClient(client, nil).GetToken(context.Background(), username, password)
 }
 
 //
 // RefreshToken refreshes an existing token and returns a new Token
-// structure.
+// structure.  Tokens obtained through SSO are refreshed through SSO;
+// older tokens use the owner API refresh grant.  In Fleet API mode,
+// tokens are always refreshed through SSO (see WithFleetClientID).
 //
-func RefreshToken(client *http.Client, token *Token) (*Token, error) {
+func (c *Client) RefreshToken(ctx context.Context, token *Token) (*Token, error) {
+	if c.fleet {
+		return c.refreshTokenFleet(ctx, token)
+	}
+	if token.SSORefreshToken != "" {
+		return c.refreshTokenSSO(ctx, token)
+	}
 
 	// Create JSON structure for authentication request
 	var auth Auth
@@ -114,13 +123,18 @@ func RefreshToken(client *http.Client, token *Token) (*Token, error) {
 	auth.RefreshToken = token.RefreshToken
 
 	// call common code
-	return tokenAuthCommon(client, &auth)
+	return c.tokenAuthCommon(ctx, &auth)
+}
+
+// RefreshToken is the package-level equivalent of Client.RefreshToken.
+func RefreshToken(client *http.Client, token *Token) (*Token, error) {
+	return newLegacyClient(client, nil).RefreshToken(context.Background(), token)
 }
 
 // Common authentication code used by GetToken and RefreshToken.
 // Basically passes an authentication structure to Telsa and
 // gets back a Token.
-func tokenAuthCommon(client *http.Client, auth *Auth) (*Token, error) {
+func (c *Client) tokenAuthCommon(ctx context.Context, auth *Auth) (*Token, error) {
 	var verbose = false
 	var t Token
 
@@ -132,7 +146,8 @@ func tokenAuthCommon(client *http.Client, auth *Auth) (*Token, error) {
 		fmt.Printf("Auth JSON: %s\n", authjson)
 	}
 
-	body, err := PostTesla(client, nil, "/oauth/token", authjson)
+	// This request is never authenticated with a bearer token
+	body, err := c.ownerAPI().doTesla(ctx, "POST", "/oauth/token", authjson, nil)
 
 	if err != nil {
 		return nil, err
@@ -157,38 +172,20 @@ func tokenAuthCommon(client *http.Client, auth *Auth) (*Token, error) {
 // atomically into place.
 //
 func SaveCachedToken(t *Token) error {
-
-	// Convert the token structure to JSON
-	tokenJSON, err := json.Marshal(t)
-	if err != nil {
-		return err
-	}
-
-	// Write it to the file
-	err = ioutil.WriteFile(TokenCachePath+TokenCachePathNewSuffix, tokenJSON, 0600)
-	if err != nil {
-		return err
-	}
-
-	// Move into place
-	err = os.Rename(TokenCachePath+TokenCachePathNewSuffix, TokenCachePath)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return NewAccountTokenStore(TokenCachePath, DefaultAccount).Save(t)
 }
 
-// GetAndCacheToken gets a new token and saves it in the local filesystem.
+// GetAndCacheToken gets a new token and saves it in the Client's
+// TokenStore (by default, the local filesystem; see WithTokenStore).
 // This function is preferred over GetToken because it (in theory anyway)
 // should result in fewer authentication calls to Tesla's servers due to
 // caching.
-func GetAndCacheToken(client *http.Client, username *string, password *string) (*Token, error) {
-	t, err := GetToken(client, username, password)
+func (c *Client) GetAndCacheToken(ctx context.Context, username *string, password *string) (*Token, error) {
+	t, err := c.GetToken(ctx, username, password)
 	if err != nil {
 		return t, err
 	}
-	err = SaveCachedToken(t)
+	err = c.store().Save(t)
 	if err != nil {
 		return t, err
 	}
@@ -196,15 +193,21 @@ func GetAndCacheToken(client *http.Client, username *string, password *string) (
 	return t, nil
 }
 
+// GetAndCacheToken is the package-level equivalent of
+// Client.GetAndCacheToken.
+func GetAndCacheToken(client *http.Client, username *string, password *string) (*Token, error) {
+	return newLegacyClient(client, nil).GetAndCacheToken(context.Background(), username, password)
+}
+
 // RefreshAndCacheToken does a refresh and saves the returned token in
-// the local filesystem
+// the Client's TokenStore (by default, the local filesystem).
 // This function is preferred over RefreshToken.
-func RefreshAndCacheToken(client *http.Client, token *Token) (*Token, error) {
-	t, err := RefreshToken(client, token)
+func (c *Client) RefreshAndCacheToken(ctx context.Context, token *Token) (*Token, error) {
+	t, err := c.RefreshToken(ctx, token)
 	if err != nil {
 		return t, err
 	}
-	err = SaveCachedToken(t)
+	err = c.store().Save(t)
 	if err != nil {
 		return t, err
 	}
@@ -212,28 +215,22 @@ func RefreshAndCacheToken(client *http.Client, token *Token) (*Token, error) {
 	return t, nil
 }
 
-// LoadCachedToken returns the token (if any) from the cache file.
-func LoadCachedToken() (*Token, error) {
-	var t Token
-
-	body, err := ioutil.ReadFile(TokenCachePath)
-	if err != nil {
-		return nil, err
-	}
-
-	// Parse response, get token structure
-	err = json.Unmarshal(body, &t)
-	if err != nil {
-		return nil, err
-	}
+// RefreshAndCacheToken is the package-level equivalent of
+// Client.RefreshAndCacheToken.
+func RefreshAndCacheToken(client *http.Client, token *Token) (*Token, error) {
+	return newLegacyClient(client, nil).RefreshAndCacheToken(context.Background(), token)
+}
 
-	return &t, nil
+// LoadCachedToken returns the token (if any) for the default account
+// from the cache file.
+func LoadCachedToken() (*Token, error) {
+	return NewAccountTokenStore(TokenCachePath, DefaultAccount).Load()
 }
 
-// DeleteCachedToken removes the cached token file.
+// DeleteCachedToken removes the default account's token from the
+// cache file.
 func DeleteCachedToken() error {
-	err := os.Remove(TokenCachePath)
-	return err
+	return NewAccountTokenStore(TokenCachePath, DefaultAccount).Delete()
 }
 
 // CheckToken returns true if a token is valid.
@@ -253,10 +250,19 @@ func TokenLifetime(t *Token) (lifetime time.Duration) {
 	return
 }
 
-// TokenTimes returns the start and end times for a token.
+// TokenTimes returns the start and end times for a token.  If the
+// access token is a JWT, its issued-at and expiry claims are used;
+// otherwise the times are computed from CreatedAt and ExpiresIn.
 func TokenTimes(t *Token) (start, end time.Time) {
 	start = time.Unix(int64(t.CreatedAt), 0)
 	end = time.Unix(int64(t.CreatedAt)+int64(t.ExpiresIn), 0)
+
+	if c, err := t.Claims(); err == nil && c.ExpiresAt != 0 {
+		end = c.Expires()
+		if c.IssuedAt != 0 {
+			start = c.Issued()
+		}
+	}
 	return
 }
 
@@ -264,97 +270,100 @@ func TokenTimes(t *Token) (start, end time.Time) {
 // General Tesla API requests
 //
 
+// GetTesla performs a GET request to the Tesla API.
+// If the Client has a TokenSource, the bearer token part of its
+// Token is used to authenticate the request.
+func (c *Client) GetTesla(ctx context.Context, endpoint string) ([]byte, error) {
+	return c.doAuthenticated(ctx, "GET", endpoint, nil)
+}
+
 // GetTesla performs a GET request to the Tesla API.
 // If a non-nil authentication Token structure is passed, the bearer
 // token part is used to authenticate the request.
 func GetTesla(client *http.Client, token *Token, endpoint string) ([]byte, error) {
-	var verbose = false
-
-	// Figure out the correct endpoint
-	var url = BaseURL + endpoint
-	if verbose {
-		fmt.Printf("URL: %s\n", url)
-	}
+	return newLegacyClient(client, token).GetTesla(context.Background(), endpoint)
+}
 
-	// Set up GET
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("User-Agent", UserAgent)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	if token != nil {
-		req.Header.Add("Authorization", "Bearer "+token.AccessToken)
-	}
+// PostTesla performs an HTTP POST request to the Tesla API.
+func (c *Client) PostTesla(ctx context.Context, endpoint string, payload []byte) ([]byte, error) {
+	return c.doAuthenticated(ctx, "POST", endpoint, payload)
+}
 
-	if verbose {
-		fmt.Printf("Headers: %s\n", req.Header)
-	}
+// PostTesla performs an HTTP POST request to the Tesla API.
+func PostTesla(client *http.Client, token *Token, endpoint string, payload []byte) ([]byte, error) {
+	return newLegacyClient(client, token).PostTesla(context.Background(), endpoint, payload)
+}
 
-	resp, err := client.Do(req)
+// doAuthenticated makes a request with a token from the Client's
+// TokenSource.  If the server rejects the token and the TokenSource
+// can refresh it, the request is retried once with the new token.
+func (c *Client) doAuthenticated(ctx context.Context, method string, endpoint string, payload []byte) ([]byte, error) {
+	token, err := c.token(ctx)
 	if err != nil {
 		return nil, err
 	}
-
-	// Try to handle certain types of HTTP status codes
-	if verbose {
-		fmt.Printf("Status: %s\n", resp.Status)
-	}
-	switch resp.StatusCode {
-	case http.StatusOK:
-		/* break */
-	default:
-		return nil, fmt.Errorf("%s", http.StatusText(resp.StatusCode))
+	body, err := c.doTesla(ctx, method, endpoint, payload, token)
+	if token == nil || !IsUnauthorized(err) {
+		return body, err
 	}
 
-	// If we get here, we can be reasonably (?) assured of a valid body.
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	r, ok := c.tokenSource.(tokenRefresher)
+	if !ok {
+		return body, err
 	}
-	if verbose {
-		fmt.Printf("Resp JSON %s\n", body)
+	token, rerr := r.Refresh(ctx, token)
+	if token == nil {
+		// Report the original error, not the failed refresh
+		return body, err
 	}
-
-	// Caller needs to parse this in the context of whatever schema it knows
-	return body, nil
-
+	body, err = c.doTesla(ctx, method, endpoint, payload, token)
+	if err == nil && rerr != nil {
+		// Couldn't save the new token
+		err = rerr
+	}
+	return body, err
 }
 
-// PostTesla performs an HTTP POST request to the Tesla API.
-func PostTesla(client *http.Client, token *Token, endpoint string, payload []byte) ([]byte, error) {
+// doTesla is the common code for GetTesla and PostTesla.  A GET
+// request has a nil payload.
+func (c *Client) doTesla(ctx context.Context, method string, endpoint string, payload []byte, token *Token) ([]byte, error) {
 	var verbose = false
 
-	// Compute endpoint URL
-	var url = BaseURL + endpoint
-	if verbose {
-		fmt.Printf("URL: %s\n", url)
-	}
-
-	// Set up POST
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	// Figure out the correct endpoint
+	base, err := c.apiBaseURL(token)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("User-Agent", UserAgent)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	if token != nil {
-		req.Header.Add("Authorization", "Bearer "+token.AccessToken)
-	}
-
+	var url = base + endpoint
 	if verbose {
-		fmt.Printf("Headers: %s\n", req.Header)
+		fmt.Printf("URL: %s\n", url)
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	// Set up the request.  This gets called for each attempt,
+	// since the body can only be read once.
+	newReq := func() (*http.Request, error) {
+		var reqBody io.Reader
+		if payload != nil {
+			reqBody = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("User-Agent", c.userAgent)
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Accept", "application/json")
+		if token != nil {
+			req.Header.Add("Authorization", "Bearer "+token.AccessToken)
+		}
+
+		if verbose {
+			fmt.Printf("Headers: %s\n", req.Header)
+		}
+		return req, nil
+	}
+
+	body, err := c.doWithRetry(ctx, method, endpoint, newReq)
 	if err != nil {
 		return nil, err
 	}
@@ -401,11 +410,11 @@ type VehiclesResponse struct {
 
 // GetVehicles performs a vehicles query to retrieve information on all
 // the Tesla vehicles associated with an account.
-func GetVehicles(client *http.Client, token *Token) (*Vehicles, error) {
+func (c *Client) GetVehicles(ctx context.Context) (*Vehicles, error) {
 	var verbose = false
 	var vr VehiclesResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles")
+	vehiclejson, err := c.GetTesla(ctx, "/api/1/vehicles")
 	if err != nil {
 		return nil, err
 	}
@@ -426,6 +435,11 @@ func GetVehicles(client *http.Client, token *Token) (*Vehicles, error) {
 	return &(vr.Response), nil
 }
 
+// GetVehicles is the package-level equivalent of Client.GetVehicles.
+func GetVehicles(client *http.Client, token *Token) (*Vehicles, error) {
+	return newLegacyClient(client, token).GetVehicles(context.Background())
+}
+
 // ChargeStateResponse is the return from a charge_state call
 type ChargeStateResponse struct {
 	Response ChargeState
@@ -467,8 +481,15 @@ type ChargeState struct {
 	ManagedChargingUserCancelled bool        `json:"managed_charging_user_cancelled"`
 	MaxRangeChargeCounter        int         `json:"max_range_charge_counter"`
 	NotEnoughPowerToHeat         bool        `json:"not_enough_power_to_heat"`
+	OffPeakChargingEnabled       bool        `json:"off_peak_charging_enabled"`
+	OffPeakChargingTimes         string      `json:"off_peak_charging_times"` // "all_week", "weekdays"
+	OffPeakHoursEndTime          int         `json:"off_peak_hours_end_time"` // minutes after midnight
+	PreconditioningEnabled       bool        `json:"preconditioning_enabled"`
+	PreconditioningTimes         string      `json:"preconditioning_times"`   // "all_week", "weekdays"
+	ScheduledChargingMode        string      `json:"scheduled_charging_mode"` // "Off", "StartAt", "DepartBy"
 	ScheduledChargingPending     bool        `json:"scheduled_charging_pending"`
 	ScheduledChargingStartTime   int         `json:"scheduled_charging_start_time"` // seconds
+	ScheduledDepartureTime       int         `json:"scheduled_departure_time"`      // seconds
 	TimeToFullCharge             float64     `json:"time_to_full_charge"`           // in hours
 	TimeStamp                    int         `json:"timestamp"`                     // ms
 	TripCharging                 bool        `json:"trip_charging"`
@@ -477,11 +498,11 @@ type ChargeState struct {
 }
 
 // GetChargeState retrieves the state of charge in the battery and various settings
-func GetChargeState(client *http.Client, token *Token, ids string) (*ChargeState, error) {
+func (c *Client) GetChargeState(ctx context.Context, ids string) (*ChargeState, error) {
 	var verbose = false
 	var csr ChargeStateResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles/"+ids+"/data_request/charge_state")
+	vehiclejson, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/data_request/charge_state")
 	if err != nil {
 		return nil, err
 	}
@@ -496,6 +517,11 @@ func GetChargeState(client *http.Client, token *Token, ids string) (*ChargeState
 	return &(csr.Response), nil
 }
 
+// GetChargeState is the package-level equivalent of Client.GetChargeState.
+func GetChargeState(client *http.Client, token *Token, ids string) (*ChargeState, error) {
+	return newLegacyClient(client, token).GetChargeState(context.Background(), ids)
+}
+
 // ClimateStateResponse encapsulates a ClimateState object
 type ClimateStateResponse struct {
 	Response ClimateState
@@ -536,11 +562,11 @@ type ClimateState struct {
 
 // GetClimateState returns information on the current internal
 // temperature and climate control system.
-func GetClimateState(client *http.Client, token *Token, ids string) (*ClimateState, error) {
+func (c *Client) GetClimateState(ctx context.Context, ids string) (*ClimateState, error) {
 	var verbose = false
 	var clsr ClimateStateResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles/"+ids+"/data_request/climate_state")
+	vehiclejson, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/data_request/climate_state")
 	if err != nil {
 		return nil, err
 	}
@@ -556,6 +582,11 @@ func GetClimateState(client *http.Client, token *Token, ids string) (*ClimateSta
 	return &(clsr.Response), nil
 }
 
+// GetClimateState is the package-level equivalent of Client.GetClimateState.
+func GetClimateState(client *http.Client, token *Token, ids string) (*ClimateState, error) {
+	return newLegacyClient(client, token).GetClimateState(context.Background(), ids)
+}
+
 // DriveStateResponse encapsulates a DriveState object.
 type DriveStateResponse struct {
 	Response DriveState
@@ -579,11 +610,11 @@ type DriveState struct {
 }
 
 // GetDriveState returns the driving and position state of the vehicle
-func GetDriveState(client *http.Client, token *Token, ids string) (*DriveState, error) {
+func (c *Client) GetDriveState(ctx context.Context, ids string) (*DriveState, error) {
 	var verbose = false
 	var dsr DriveStateResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles/"+ids+"/data_request/drive_state")
+	vehiclejson, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/data_request/drive_state")
 	if err != nil {
 		return nil, err
 	}
@@ -599,6 +630,11 @@ func GetDriveState(client *http.Client, token *Token, ids string) (*DriveState,
 	return &(dsr.Response), nil
 }
 
+// GetDriveState is the package-level equivalent of Client.GetDriveState.
+func GetDriveState(client *http.Client, token *Token, ids string) (*DriveState, error) {
+	return newLegacyClient(client, token).GetDriveState(context.Background(), ids)
+}
+
 // GuiSettingsResponse encapsulates a GuiSettings object
 type GuiSettingsResponse struct {
 	Response GuiSettings
@@ -616,11 +652,11 @@ type GuiSettings struct {
 
 // GetGuiSettings returns various information about the GUI settings
 // of the car, such as unit format and range display
-func GetGuiSettings(client *http.Client, token *Token, ids string) (*GuiSettings, error) {
+func (c *Client) GetGuiSettings(ctx context.Context, ids string) (*GuiSettings, error) {
 	var verbose = false
 	var gsr GuiSettingsResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles/"+ids+"/data_request/gui_settings")
+	vehiclejson, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/data_request/gui_settings")
 	if err != nil {
 		return nil, err
 	}
@@ -635,6 +671,11 @@ func GetGuiSettings(client *http.Client, token *Token, ids string) (*GuiSettings
 	return &(gsr.Response), nil
 }
 
+// GetGuiSettings is the package-level equivalent of Client.GetGuiSettings.
+func GetGuiSettings(client *http.Client, token *Token, ids string) (*GuiSettings, error) {
+	return newLegacyClient(client, token).GetGuiSettings(context.Background(), ids)
+}
+
 // VehicleStateResponse encapsulates a VehicleState object
 type VehicleStateResponse struct {
 	Response VehicleState
@@ -650,6 +691,8 @@ type VehicleState struct {
 	CenterDisplayState      int                        `json:"center_display_state"`
 	Df                      int                        `json:"df"`
 	Dr                      int                        `json:"dr"`
+	FdWindow                int                        `json:"fd_window"`
+	FpWindow                int                        `json:"fp_window"`
 	Ft                      int                        `json:"ft"`
 	HomelinkNearby          bool                       `json:"homelink_nearby"`
 	IsUserPresent           bool                       `json:"is_user_present"`
@@ -663,7 +706,10 @@ type VehicleState struct {
 	Pr                      int                        `json:"pr"`
 	RemoteStart             bool                       `json:"remote_start"`
 	RemoteStartSupported    bool                       `json:"remote_start_started"`
+	RdWindow                int                        `json:"rd_window"`
+	RpWindow                int                        `json:"rp_window"`
 	Rt                      int                        `json:"rt"`
+	SentryMode              bool                       `json:"sentry_mode"`
 	SoftwareUpdate          VehicleStateSoftwareUpdate `json:"software_update"`
 	SpeedLimitMode          VehicleStateSpeedLimitMode `json:"speed_limit_mode"`
 	SunRoofPercentOpen      int                        `json:"sun_roof_percent_open"`
@@ -681,8 +727,13 @@ type VehicleStateMediaState struct {
 
 // A VehicleStateSoftwareUpdate returns information on pending software updates
 type VehicleStateSoftwareUpdate struct {
-	ExpectedDurationSec int    `json:"expected_duration_sec"`
-	Status              string `json:"status"`
+	DownloadPerc           int    `json:"download_perc"`
+	ExpectedDurationSec    int    `json:"expected_duration_sec"`
+	InstallPerc            int    `json:"install_perc"`
+	ScheduledTimeMs        int64  `json:"scheduled_time_ms"`
+	Status                 string `json:"status"` // "", "available", "downloading", "scheduled", "installing"...
+	Version                string `json:"version"`
+	WarningTimeRemainingMs int64  `json:"warning_time_remaining_ms"`
 }
 
 // A VehicleStateSpeedLimitMode returns the speed limiting parameters
@@ -696,11 +747,11 @@ type VehicleStateSpeedLimitMode struct {
 
 // GetVehicleState returns the vehicle's physical state, such as which
 // doors are open.
-func GetVehicleState(client *http.Client, token *Token, ids string) (*VehicleState, error) {
+func (c *Client) GetVehicleState(ctx context.Context, ids string) (*VehicleState, error) {
 	var verbose = false
 	var vsr VehicleStateResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles/"+ids+"/data_request/vehicle_state")
+	vehiclejson, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/data_request/vehicle_state")
 	if err != nil {
 		return nil, err
 	}
@@ -715,6 +766,11 @@ func GetVehicleState(client *http.Client, token *Token, ids string) (*VehicleSta
 	return &(vsr.Response), nil
 }
 
+// GetVehicleState is the package-level equivalent of Client.GetVehicleState.
+func GetVehicleState(client *http.Client, token *Token, ids string) (*VehicleState, error) {
+	return newLegacyClient(client, token).GetVehicleState(context.Background(), ids)
+}
+
 // VehicleConfigResponse encapsulates a VehicleConfig
 type VehicleConfigResponse struct {
 	Response VehicleConfig
@@ -748,11 +804,11 @@ type VehicleConfig struct {
 }
 
 // GetVehicleConfig performs a vehicle_config call
-func GetVehicleConfig(client *http.Client, token *Token, ids string) (*VehicleConfig, error) {
+func (c *Client) GetVehicleConfig(ctx context.Context, ids string) (*VehicleConfig, error) {
 	var verbose = false
 	var vcr VehicleConfigResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles/"+ids+"/data_request/vehicle_config")
+	vehiclejson, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/data_request/vehicle_config")
 	if err != nil {
 		return nil, err
 	}
@@ -767,6 +823,11 @@ func GetVehicleConfig(client *http.Client, token *Token, ids string) (*VehicleCo
 	return &(vcr.Response), nil
 }
 
+// GetVehicleConfig is the package-level equivalent of Client.GetVehicleConfig.
+func GetVehicleConfig(client *http.Client, token *Token, ids string) (*VehicleConfig, error) {
+	return newLegacyClient(client, token).GetVehicleConfig(context.Background(), ids)
+}
+
 // VehicleDataResponse is the return from a vehicle_data call
 type VehicleDataResponse struct {
 	Response VehicleData
@@ -785,11 +846,11 @@ type VehicleData struct {
 }
 
 // GetVehicleData performs a vehicle_data call
-func GetVehicleData(client *http.Client, token *Token, ids string) (*VehicleData, error) {
+func (c *Client) GetVehicleData(ctx context.Context, ids string) (*VehicleData, error) {
 	var verbose = false
 	var vdr VehicleDataResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles/"+ids+"/vehicle_data")
+	vehiclejson, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/vehicle_data")
 	if err != nil {
 		return nil, err
 	}
@@ -804,17 +865,22 @@ func GetVehicleData(client *http.Client, token *Token, ids string) (*VehicleData
 	return &(vdr.Response), nil
 }
 
+// GetVehicleData is the package-level equivalent of Client.GetVehicleData.
+func GetVehicleData(client *http.Client, token *Token, ids string) (*VehicleData, error) {
+	return newLegacyClient(client, token).GetVehicleData(context.Background(), ids)
+}
+
 // MobileEnabledResponse is the return from a mobile_enabled call
 type MobileEnabledResponse struct {
 	Response bool `json:"response"`
 }
 
 // GetMobileEnabled returns whether mobile access is enabled
-func GetMobileEnabled(client *http.Client, token *Token, ids string) (bool, error) {
+func (c *Client) GetMobileEnabled(ctx context.Context, ids string) (bool, error) {
 	var verbose = false
 	var mer MobileEnabledResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles/"+ids+"/mobile_enabled")
+	vehiclejson, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/mobile_enabled")
 	if err != nil {
 		return false, err
 	}
@@ -830,6 +896,11 @@ func GetMobileEnabled(client *http.Client, token *Token, ids string) (bool, erro
 	return mer.Response, nil
 }
 
+// GetMobileEnabled is the package-level equivalent of Client.GetMobileEnabled.
+func GetMobileEnabled(client *http.Client, token *Token, ids string) (bool, error) {
+	return newLegacyClient(client, token).GetMobileEnabled(context.Background(), ids)
+}
+
 // Nearby Charging Sites
 
 // ChargerLocation represents the physical coordinates of a charging station.
@@ -856,6 +927,7 @@ type DestinationCharger struct {
 // information on stall occupancy.
 type Supercharger struct {
 	Charger
+	ID              int  `json:"id"`
 	AvailableStalls int  `json:"available_stalls"`
 	TotalStalls     int  `json:"total_stalls"`
 	SiteClosed      bool `json:"site_closed"`
@@ -874,11 +946,11 @@ type NearbyChargingSitesResponse struct {
 }
 
 // GetNearbyChargers retrieves the chargers closest to a given vehicle.
-func GetNearbyChargers(client *http.Client, token *Token, ids string) (NearbyChargingSitesResponse, error) {
+func (c *Client) GetNearbyChargers(ctx context.Context, ids string) (NearbyChargingSitesResponse, error) {
 	var verbose = false
 	var ncsr NearbyChargingSitesResponse
 
-	vehiclejson, err := GetTesla(client, token, "/api/1/vehicles/"+ids+"/nearby_charging_sites")
+	vehiclejson, err := c.getVehicleData(ctx, ids, "/api/1/vehicles/"+ids+"/nearby_charging_sites")
 	if err != nil {
 		return ncsr, err
 	}
@@ -893,3 +965,8 @@ func GetNearbyChargers(client *http.Client, token *Token, ids string) (NearbyCha
 
 	return ncsr, nil
 }
+
+// GetNearbyChargers is the package-level equivalent of Client.GetNearbyChargers.
+func GetNearbyChargers(client *http.Client, token *Token, ids string) (NearbyChargingSitesResponse, error) {
+	return newLegacyClient(client, token).GetNearbyChargers(context.Background(), ids)
+}
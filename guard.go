@@ -0,0 +1,232 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+//
+// Command safety: dry-run mode, allowlists and auditing
+//
+
+// ErrCommandNotAllowed is returned (wrapped) when a CommandPolicy
+// doesn't permit a command.
+var ErrCommandNotAllowed = errors.New("command not allowed by policy")
+
+// DryRunReason is the Reason in the CommandResult of a command that
+// wasn't sent because of dry-run mode.
+const DryRunReason = "dry_run"
+
+// A CommandPolicy lists the commands (by API name, such as
+// "door_unlock") that may be sent to each vehicle, keyed by vehicle ID.
+// The key AllVehicles applies to all vehicles, and the command
+// AllCommands permits every command.  So {"*": ["*"]} allows anything
+// to be sent anywhere, while {"12345": ["*"]} allows anything to be
+// sent to vehicle 12345 only.  A nil CommandPolicy allows everything;
+// an empty one allows nothing.
+type CommandPolicy map[string][]string
+
+// AllVehicles is the CommandPolicy key that applies to every vehicle.
+const AllVehicles = "*"
+
+// AllCommands is the CommandPolicy entry that permits every command.
+const AllCommands = "*"
+
+// Allowed returns true if the policy permits a command to be sent to
+// a vehicle.
+func (p CommandPolicy) Allowed(ids string, command string) bool {
+	if p == nil {
+		return true
+	}
+	for _, key := range []string{ids, AllVehicles} {
+		for _, allowed := range p[key] {
+			if allowed == command || allowed == AllCommands {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LoadCommandPolicy reads a CommandPolicy from a JSON file, for example:
+//
+//	{ "12345678901234567": [ "charge_start", "charge_stop" ],
+//	  "*": [ "auto_conditioning_start", "auto_conditioning_stop" ] }
+func LoadCommandPolicy(path string) (CommandPolicy, error) {
+	var p CommandPolicy
+
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		// "null" shouldn't mean "allow everything"
+		p = CommandPolicy{}
+	}
+	return p, nil
+}
+
+// An AuditRecord describes one attempted command.
+type AuditRecord struct {
+	Time    time.Time       `json:"time"`
+	Vehicle string          `json:"vehicle"`
+	Command string          `json:"command"`
+	Params  json.RawMessage `json:"params,omitempty"` // PINs and passwords redacted
+	DryRun  bool            `json:"dry_run,omitempty"`
+	Allowed bool            `json:"allowed"`
+	Result  bool            `json:"result"`
+	Reason  string          `json:"reason,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// An AuditLog appends AuditRecords to a file, one JSON object per line.
+// It is safe for concurrent use.
+type AuditLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenAuditLog opens (creating if necessary) an audit log file for
+// appending.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{f: f}, nil
+}
+
+// Write appends a record to the audit log.
+func (al *AuditLog) Write(rec *AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	_, err = al.f.Write(line)
+	return err
+}
+
+// Close closes the audit log file.
+func (al *AuditLog) Close() error {
+	return al.f.Close()
+}
+
+// A CommandGuard is consulted before a Client sends any vehicle command.
+// Commands not permitted by Policy fail with ErrCommandNotAllowed.
+// In DryRun mode, permitted commands aren't sent; they succeed with
+// a Reason of DryRunReason.  Every attempt is recorded in AuditLog,
+// if it is non-nil.  (Without an AuditLog, dry-run commands are logged
+// with the log package's standard logger, so that they aren't silently
+// dropped.)
+type CommandGuard struct {
+	DryRun   bool
+	Policy   CommandPolicy
+	AuditLog *AuditLog
+}
+
+// WithCommandGuard makes a Client check all vehicle commands with g.
+func WithCommandGuard(g *CommandGuard) ClientOption {
+	return func(c *Client) {
+		c.guard = g
+	}
+}
+
+// redactedParams are the names of command parameters whose values
+// are never written to the audit log.
+var redactedParams = map[string]bool{
+	"pin":      true,
+	"password": true,
+}
+
+// redact returns the JSON payload of a command with any PINs or
+// passwords replaced.
+func redact(payload []byte) json.RawMessage {
+	var params map[string]interface{}
+	if json.Unmarshal(payload, &params) != nil {
+		// Not an object, so nothing to redact...but don't
+		// take any chances.
+		return nil
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	for k := range params {
+		if redactedParams[k] {
+			params[k] = redactedPIN
+		}
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// guardCommand sends a command subject to a CommandGuard, calling send
+// if the command should actually be sent.
+func (g *CommandGuard) guardCommand(ids string, command string, payload []byte, send func() (*CommandResult, error)) (*CommandResult, error) {
+	rec := AuditRecord{
+		Time:    time.Now(),
+		Vehicle: ids,
+		Command: command,
+		Params:  redact(payload),
+		DryRun:  g.DryRun,
+		Allowed: g.Policy.Allowed(ids, command),
+	}
+
+	var res *CommandResult
+	var err error
+	switch {
+	case !rec.Allowed:
+		err = fmt.Errorf("%s on vehicle %s: %w", command, ids, ErrCommandNotAllowed)
+	case g.DryRun:
+		res = &CommandResult{Result: true, Reason: DryRunReason, Command: command}
+	default:
+		res, err = send()
+	}
+
+	if res != nil {
+		rec.Result = res.Result
+		rec.Reason = res.Reason
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if g.AuditLog != nil {
+		if aerr := g.AuditLog.Write(&rec); aerr != nil && err == nil {
+			// Don't hide the result of a command that was
+			// actually sent, but do tell the caller.
+			err = fmt.Errorf("audit log: %w", aerr)
+		}
+	} else if g.DryRun && rec.Allowed {
+		if rec.Params != nil {
+			log.Printf("dry run: %s on vehicle %s not sent (params %s)\n", command, ids, rec.Params)
+		} else {
+			log.Printf("dry run: %s on vehicle %s not sent\n", command, ids)
+		}
+	}
+
+	return res, err
+}
@@ -0,0 +1,113 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+//
+// Access token introspection
+//
+// Tesla access tokens are JWTs.  Their claims are decoded here for
+// informational purposes only; the signature is not verified, so the
+// claims must not be trusted for anything security-related.
+//
+
+// ErrNotJWT is returned when an access token isn't a JWT (for example,
+// an old-style owner API token).
+var ErrNotJWT = errors.New("access token is not a JWT")
+
+// audience is a JWT "aud" claim, which may be a string or an array
+// of strings.
+type audience []string
+
+// UnmarshalJSON decodes either form of audience.
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	err := json.Unmarshal(b, &ss)
+	if err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// TokenClaims are the claims of interest in an access token.
+type TokenClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"` // seconds since the epoch
+	ExpiresAt int64    `json:"exp,omitempty"` // seconds since the epoch
+	Scopes    []string `json:"scp,omitempty"`
+	OUCode    string   `json:"ou_code,omitempty"` // region, for example "NA" or "EU"
+}
+
+// ParseTokenClaims decodes the claims in a JWT access token, without
+// verifying its signature.
+func ParseTokenClaims(accessToken string) (*TokenClaims, error) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrNotJWT
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, ErrNotJWT
+	}
+
+	var c TokenClaims
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Claims decodes the claims in a Token's access token.
+func (t *Token) Claims() (*TokenClaims, error) {
+	return ParseTokenClaims(t.AccessToken)
+}
+
+// Issued returns the time the token was issued, or the zero time if
+// unknown.
+func (c *TokenClaims) Issued() time.Time {
+	if c.IssuedAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// Expires returns the time the token expires, or the zero time if
+// unknown.
+func (c *TokenClaims) Expires() time.Time {
+	if c.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// HasScope returns true if the token was granted a scope.
+func (c *TokenClaims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
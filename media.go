@@ -0,0 +1,70 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"fmt"
+)
+
+//
+// Media commands
+//
+
+// MediaVolumeMax is the highest volume setting for AdjustVolume.
+const MediaVolumeMax = 11.0
+
+// mediaCommand sends a media command, after checking that the vehicle
+// allows remote control of media.
+func (c *Client) mediaCommand(ctx context.Context, ids string, command string, params interface{}) (*CommandResult, error) {
+	vs, err := c.GetVehicleState(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if !vs.MediaState.RemoteControlEnabled {
+		return nil, fmt.Errorf("media remote control: %w", ErrNotSupported)
+	}
+	return c.PostCommand(ctx, ids, command, params)
+}
+
+// MediaTogglePlayback pauses or resumes media playback.
+func (c *Client) MediaTogglePlayback(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.mediaCommand(ctx, ids, "media_toggle_playback", nil)
+}
+
+// MediaNextTrack skips to the next track.
+func (c *Client) MediaNextTrack(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.mediaCommand(ctx, ids, "media_next_track", nil)
+}
+
+// MediaPrevTrack skips to the previous track.
+func (c *Client) MediaPrevTrack(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.mediaCommand(ctx, ids, "media_prev_track", nil)
+}
+
+// MediaVolumeUp turns the volume up one step.
+func (c *Client) MediaVolumeUp(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.mediaCommand(ctx, ids, "media_volume_up", nil)
+}
+
+// MediaVolumeDown turns the volume down one step.
+func (c *Client) MediaVolumeDown(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.mediaCommand(ctx, ids, "media_volume_down", nil)
+}
+
+// AdjustVolume sets the volume, from 0 to MediaVolumeMax.
+func (c *Client) AdjustVolume(ctx context.Context, ids string, volume float64) (*CommandResult, error) {
+	if volume < 0.0 || volume > MediaVolumeMax {
+		return nil, fmt.Errorf("volume %.1f out of range", volume)
+	}
+	params := struct {
+		Volume float64 `json:"volume"`
+	}{volume}
+	return c.mediaCommand(ctx, ids, "adjust_volume", &params)
+}
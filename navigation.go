@@ -0,0 +1,99 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//
+// Navigation commands
+//
+
+// NavigationLocale is the locale passed with navigation requests.
+var NavigationLocale = "en-US"
+
+// checkNavigation returns an error if a vehicle can't accept
+// navigation requests.
+func (c *Client) checkNavigation(ctx context.Context, ids string) error {
+	vc, err := c.GetVehicleConfig(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if !vc.CanAcceptNavigationRequests {
+		return fmt.Errorf("navigation requests: %w", ErrNotSupported)
+	}
+	return nil
+}
+
+// NavigationRequest sends an address (or any other text that the
+// vehicle's navigation system can search for) to the vehicle, the
+// same way as sharing a location from the mobile app.
+func (c *Client) NavigationRequest(ctx context.Context, ids string, address string) (*CommandResult, error) {
+	if address == "" {
+		return nil, errors.New("empty navigation address")
+	}
+	if err := c.checkNavigation(ctx, ids); err != nil {
+		return nil, err
+	}
+
+	params := struct {
+		Type  string `json:"type"`
+		Value struct {
+			Text string `json:"android.intent.extra.TEXT"`
+		} `json:"value"`
+		Locale      string `json:"locale"`
+		TimestampMs string `json:"timestamp_ms"`
+	}{Type: "share_ext_content_raw", Locale: NavigationLocale}
+	params.Value.Text = address
+	params.TimestampMs = strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	return c.PostCommand(ctx, ids, "share", &params)
+}
+
+// NavigationGPSRequest sends a destination, as coordinates, to the
+// vehicle.  The order is the position of the destination in a trip
+// with multiple stops (zero for a single destination).
+func (c *Client) NavigationGPSRequest(ctx context.Context, ids string, lat float64, lon float64, order int) (*CommandResult, error) {
+	if lat < -90.0 || lat > 90.0 || lon < -180.0 || lon > 180.0 {
+		return nil, fmt.Errorf("invalid coordinates %f,%f", lat, lon)
+	}
+	if err := c.checkNavigation(ctx, ids); err != nil {
+		return nil, err
+	}
+
+	params := struct {
+		locationParams
+		Order int `json:"order"`
+	}{locationParams{lat, lon}, order}
+	return c.PostCommand(ctx, ids, "navigation_gps_request", &params)
+}
+
+// NavigationSCRequest sends a Supercharger, as returned by
+// GetNearbyChargers, as a destination to the vehicle.
+func (c *Client) NavigationSCRequest(ctx context.Context, ids string, sc *Supercharger, order int) (*CommandResult, error) {
+	if sc == nil {
+		return nil, errors.New("no Supercharger given")
+	}
+	if sc.ID == 0 {
+		return nil, fmt.Errorf("Supercharger %q has no ID", sc.Name)
+	}
+	if err := c.checkNavigation(ctx, ids); err != nil {
+		return nil, err
+	}
+
+	params := struct {
+		ID    int `json:"id"`
+		Order int `json:"order"`
+	}{sc.ID, order}
+	return c.PostCommand(ctx, ids, "navigation_sc_request", &params)
+}
@@ -10,10 +10,10 @@ package gotesla
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"google.golang.org/protobuf/encoding/protojson"
-	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
@@ -67,11 +67,11 @@ type MeterAggregate struct {
 // GetMeterAggregate retrieves a MeterAggregate from a local
 // Powerwall gateway.  No authentication is required for this
 // call.
-func GetMeterAggregate(client *http.Client, hostname string, pwa *PowerwallAuth) (*MeterAggregate, error) {
+func (c *Client) GetMeterAggregate(ctx context.Context, hostname string, pwa *PowerwallAuth) (*MeterAggregate, error) {
 	var verbose = false
 	var ma MeterAggregate
 
-	body, err := GetPowerwall(client, hostname, "/api/meters/aggregates", pwa)
+	body, err := c.GetPowerwall(ctx, hostname, "/api/meters/aggregates", pwa)
 
 	if err != nil {
 		return nil, err
@@ -89,6 +89,11 @@ func GetMeterAggregate(client *http.Client, hostname string, pwa *PowerwallAuth)
 	return &ma, nil
 }
 
+// GetMeterAggregate is the package-level equivalent of Client.GetMeterAggregate.
+func GetMeterAggregate(client *http.Client, hostname string, pwa *PowerwallAuth) (*MeterAggregate, error) {
+	return newLegacyClient(client, nil).GetMeterAggregate(context.Background(), hostname, pwa)
+}
+
 type SystemStatusResponse struct {
 	BatteryTargetPower     float64        `json:"battery_target_power"`
 	NominalFullPackEnergy  int            `json:"nominal_full_pack_energy"`
@@ -107,11 +112,11 @@ type BatteryBlock struct {
 	EnergyDischarged       int `json:"energy_discharged"`
 }
 
-func GetSystemStatus(client *http.Client, hostname string, pwa *PowerwallAuth) (*SystemStatusResponse, error) {
+func (c *Client) GetSystemStatus(ctx context.Context, hostname string, pwa *PowerwallAuth) (*SystemStatusResponse, error) {
 	var verbose = false
 	var sysstat SystemStatusResponse
 
-	body, err := GetPowerwall(client, hostname, "/api/system_status", pwa)
+	body, err := c.GetPowerwall(ctx, hostname, "/api/system_status", pwa)
 
 	if err != nil {
 		return nil, err
@@ -128,6 +133,11 @@ func GetSystemStatus(client *http.Client, hostname string, pwa *PowerwallAuth) (
 	return &sysstat, nil
 }
 
+// GetSystemStatus is the package-level equivalent of Client.GetSystemStatus.
+func GetSystemStatus(client *http.Client, hostname string, pwa *PowerwallAuth) (*SystemStatusResponse, error) {
+	return newLegacyClient(client, nil).GetSystemStatus(context.Background(), hostname, pwa)
+}
+
 // A Soe structure gives the current state of energy of the Powerwall
 // batteries (total, as a value between 0-100).
 type Soe struct {
@@ -137,11 +147,11 @@ type Soe struct {
 // GetSoe returns the state of energy of the Powerwall batteries.
 // Unlike some other calls in this library, it doesn't return the
 // structure, just a float64 value (and error if applicable).
-func GetSoe(client *http.Client, hostname string, pwa *PowerwallAuth) (float64, error) {
+func (c *Client) GetSoe(ctx context.Context, hostname string, pwa *PowerwallAuth) (float64, error) {
 	var verbose = false
 	var soe Soe
 
-	body, err := GetPowerwall(client, hostname, "/api/system_status/soe", pwa)
+	body, err := c.GetPowerwall(ctx, hostname, "/api/system_status/soe", pwa)
 
 	if err != nil {
 		return 0.0, err
@@ -159,6 +169,11 @@ func GetSoe(client *http.Client, hostname string, pwa *PowerwallAuth) (float64,
 	return soe.Percentage, nil
 }
 
+// GetSoe is the package-level equivalent of Client.GetSoe.
+func GetSoe(client *http.Client, hostname string, pwa *PowerwallAuth) (float64, error) {
+	return newLegacyClient(client, nil).GetSoe(context.Background(), hostname, pwa)
+}
+
 // GridStatusResponse is a structure that gives the current grid
 // status as a string, as defined in the following constants.
 type GridStatusResponse struct {
@@ -183,11 +198,11 @@ const (
 // GetGridStatus returns the grid status as a GridStatus value.
 // We do it this way in order to avoid the caller needing to parse
 // the response strings.
-func GetGridStatus(client *http.Client, hostname string, pwa *PowerwallAuth) (GridStatus, error) {
+func (c *Client) GetGridStatus(ctx context.Context, hostname string, pwa *PowerwallAuth) (GridStatus, error) {
 	var verbose = false
 	var gsr GridStatusResponse
 
-	body, err := GetPowerwall(client, hostname, "/api/system_status/grid_status", pwa)
+	body, err := c.GetPowerwall(ctx, hostname, "/api/system_status/grid_status", pwa)
 
 	if err != nil {
 		return GridStatusUnknown, err
@@ -215,6 +230,11 @@ func GetGridStatus(client *http.Client, hostname string, pwa *PowerwallAuth) (Gr
 	return gs, nil
 }
 
+// GetGridStatus is the package-level equivalent of Client.GetGridStatus.
+func GetGridStatus(client *http.Client, hostname string, pwa *PowerwallAuth) (GridStatus, error) {
+	return newLegacyClient(client, nil).GetGridStatus(context.Background(), hostname, pwa)
+}
+
 // SiteMasterResponse
 type SiteMasterResponse struct {
 	Running          bool   `json:"running"`
@@ -222,11 +242,11 @@ type SiteMasterResponse struct {
 	ConnectedToTesla bool   `json:"connected_to_tesla"`
 }
 
-func GetSiteMaster(client *http.Client, hostname string, pwa *PowerwallAuth) (*SiteMasterResponse, error) {
+func (c *Client) GetSiteMaster(ctx context.Context, hostname string, pwa *PowerwallAuth) (*SiteMasterResponse, error) {
 	var verbose = false
 	var smr SiteMasterResponse
 
-	body, err := GetPowerwall(client, hostname, "/api/sitemaster", pwa)
+	body, err := c.GetPowerwall(ctx, hostname, "/api/sitemaster", pwa)
 
 	if err != nil {
 		return nil, err
@@ -244,6 +264,11 @@ func GetSiteMaster(client *http.Client, hostname string, pwa *PowerwallAuth) (*S
 	return &smr, nil
 }
 
+// GetSiteMaster is the package-level equivalent of Client.GetSiteMaster.
+func GetSiteMaster(client *http.Client, hostname string, pwa *PowerwallAuth) (*SiteMasterResponse, error) {
+	return newLegacyClient(client, nil).GetSiteMaster(context.Background(), hostname, pwa)
+}
+
 type VitalDevices struct {
 	STSTSM      STSTSM
 	TESYNC      TESYNC
@@ -476,10 +501,10 @@ type TESLAPV struct {
 	NameplateRealPowerW uint64
 }
 
-func GetVitals(client *http.Client, hostname string, pwa *PowerwallAuth) (*VitalDevices, error) {
+func (c *Client) GetVitals(ctx context.Context, hostname string, pwa *PowerwallAuth) (*VitalDevices, error) {
 	var verbose = false
 
-	body, err := GetPowerwall(client, hostname, "/api/devices/vitals", pwa)
+	body, err := c.GetPowerwall(ctx, hostname, "/api/devices/vitals", pwa)
 
 	if err != nil {
 		return nil, err
@@ -988,9 +1013,15 @@ func GetVitals(client *http.Client, hostname string, pwa *PowerwallAuth) (*Vital
 	return &vd, nil
 }
 
+// GetVitals is the package-level equivalent of Client.GetVitals.
+func GetVitals(client *http.Client, hostname string, pwa *PowerwallAuth) (*VitalDevices, error) {
+	return newLegacyClient(client, nil).GetVitals(context.Background(), hostname, pwa)
+}
+
 // GetPowerwall performs a GET request to a local Tesla Powerwall gateway.
-// It doesn't do authentication yet.
-func GetPowerwall(client *http.Client, hostname string, endpoint string, pwa *PowerwallAuth) ([]byte, error) {
+// If a non-nil PowerwallAuth is passed, its token is used to authenticate
+// the request.
+func (c *Client) GetPowerwall(ctx context.Context, hostname string, endpoint string, pwa *PowerwallAuth) ([]byte, error) {
 
 	var verbose = false
 
@@ -1000,42 +1031,27 @@ func GetPowerwall(client *http.Client, hostname string, endpoint string, pwa *Po
 		fmt.Printf("URL: %s\n", url)
 	}
 
-	// Set up GET
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("User-Agent", UserAgent)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-
-	if pwa != nil {
-		req.Header.Add("Cookie", "AuthCookie="+pwa.Token)
-	}
-
-	if verbose {
-		fmt.Printf("Headers: %s\n", req.Header)
-	}
+	// Set up GET.  This gets called for each attempt.
+	newReq := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("User-Agent", c.userAgent)
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Accept", "application/json")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
+		if pwa != nil {
+			req.Header.Add("Cookie", "AuthCookie="+pwa.Token)
+		}
 
-	// Try to handle certain types of HTTP status codes
-	if verbose {
-		fmt.Printf("Status: %s\n", resp.Status)
-	}
-	switch resp.StatusCode {
-	case http.StatusOK:
-		/* break */
-	default:
-		return nil, fmt.Errorf("%s", http.StatusText(resp.StatusCode))
+		if verbose {
+			fmt.Printf("Headers: %s\n", req.Header)
+		}
+		return req, nil
 	}
 
-	// If we get here, we can be reasonably (?) assured of a valid body.
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := c.doWithRetry(ctx, "GET", endpoint, newReq)
 	if err != nil {
 		return nil, err
 	}
@@ -1048,9 +1064,14 @@ func GetPowerwall(client *http.Client, hostname string, endpoint string, pwa *Po
 
 }
 
+// GetPowerwall is the package-level equivalent of Client.GetPowerwall.
+func GetPowerwall(client *http.Client, hostname string, endpoint string, pwa *PowerwallAuth) ([]byte, error) {
+	return newLegacyClient(client, nil).GetPowerwall(context.Background(), hostname, endpoint, pwa)
+}
+
 // GetPowerwallAuth gets a token (plus some other stuff) for authentication
 // on a local Powerwall gateway
-func GetPowerwallAuth(client *http.Client, hostname string, email string, password string) (*PowerwallAuth, error) {
+func (c *Client) GetPowerwallAuth(ctx context.Context, hostname string, email string, password string) (*PowerwallAuth, error) {
 
 	type PowerwallLogin struct {
 		Username string `json:"username"`
@@ -1074,39 +1095,24 @@ func GetPowerwallAuth(client *http.Client, hostname string, email string, passwo
 	pl.Password = password
 	payload, err := json.Marshal(pl)
 
-	// Set up POST
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Add("User-Agent", UserAgent)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
+	// Set up POST.  This gets called for each attempt.
+	newReq := func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
 
-	if verbose {
-		fmt.Printf("Headers: %s\n", req.Header)
-	}
+		req.Header.Add("User-Agent", c.userAgent)
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Accept", "application/json")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	// Try to handle certain types of HTTP status codes
-	if verbose {
-		fmt.Printf("Status: %s\n", resp.Status)
-	}
-	switch resp.StatusCode {
-	case http.StatusOK:
-		/* break */
-	default:
-		return nil, fmt.Errorf("%s", http.StatusText(resp.StatusCode))
+		if verbose {
+			fmt.Printf("Headers: %s\n", req.Header)
+		}
+		return req, nil
 	}
 
-	// If we get here, we can be reasonably (?) assured of a valid body.
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := c.doWithRetry(ctx, "POST", "/api/login/Basic", newReq)
 	if err != nil {
 		return nil, err
 	}
@@ -1125,3 +1131,9 @@ func GetPowerwallAuth(client *http.Client, hostname string, email string, passwo
 
 	return &pa, nil
 }
+
+// GetPowerwallAuth is the package-level equivalent of
+// Client.GetPowerwallAuth.
+func GetPowerwallAuth(client *http.Client, hostname string, email string, password string) (*PowerwallAuth, error) {
+	return newLegacyClient(client, nil).GetPowerwallAuth(context.Background(), hostname, email, password)
+}
@@ -0,0 +1,397 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+//
+// Persistent command queue
+//
+
+// ErrSensitiveParams is returned by Enqueue for a command whose
+// parameters include a PIN or password, which would otherwise be
+// stored in the queue file.
+var ErrSensitiveParams = errors.New("command parameters include a PIN or password")
+
+// QueueStatus is the state of a QueuedCommand.
+type QueueStatus string
+
+// QueueStatus values
+const (
+	QueuePending   QueueStatus = "pending"
+	QueueDelivered QueueStatus = "delivered"
+	QueueFailed    QueueStatus = "failed"
+	QueueExpired   QueueStatus = "expired"
+)
+
+// A QueuedCommand is a vehicle command held in a CommandQueue.
+// Once it has been delivered (or has failed or expired), its
+// outcome is recorded in Status, Result, Reason and Error.
+type QueuedCommand struct {
+	ID       string          `json:"id"`
+	Vehicle  string          `json:"vehicle"`
+	Command  string          `json:"command"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Enqueued time.Time       `json:"enqueued"`
+	Expires  time.Time       `json:"expires"` // zero for never
+	Attempts int             `json:"attempts"`
+	Status   QueueStatus     `json:"status"`
+	Finished time.Time       `json:"finished"`
+	Result   bool            `json:"result"`
+	Reason   string          `json:"reason,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// expired returns true if a command has passed its expiry time.
+func (qc *QueuedCommand) expired(now time.Time) bool {
+	return !qc.Expires.IsZero() && now.After(qc.Expires)
+}
+
+// finish records the final outcome of a command.
+func (qc *QueuedCommand) finish(status QueueStatus, res *CommandResult, err error) {
+	qc.Status = status
+	qc.Finished = time.Now()
+	if res != nil {
+		qc.Result = res.Result
+		qc.Reason = res.Reason
+	}
+	if err != nil {
+		qc.Error = err.Error()
+	} else {
+		qc.Error = ""
+	}
+}
+
+// A CommandQueue holds vehicle commands until they can be delivered,
+// for example because the vehicle is asleep or out of coverage.
+// Commands for each vehicle are delivered in the order they were
+// enqueued.  The queue is saved to a file (readable only by its owner)
+// after every change, so it survives restarts.  Commands that carry a
+// PIN or password can't be queued, since their parameters would be
+// stored in that file.
+//
+// A CommandQueue is safe for concurrent use within a single process.
+type CommandQueue struct {
+	// MaxAttempts is the number of times delivery of a command is
+	// attempted before it is marked as failed.  Only retryable
+	// errors (see IsRetryable) count; other errors fail the
+	// command immediately.
+	MaxAttempts int
+
+	// Wake controls whether the queue wakes a vehicle in order to
+	// deliver commands.  If false, the queue waits for the vehicle
+	// to come online by itself.
+	Wake bool
+
+	// WakeTimeout limits how long to wait for a vehicle to come
+	// online after waking it.
+	WakeTimeout time.Duration
+
+	mu       sync.Mutex
+	path     string
+	commands []*QueuedCommand
+	seq      int
+}
+
+// Default parameters for a new CommandQueue.
+const (
+	DefaultQueueMaxAttempts = 5
+	DefaultQueueWakeTimeout = 2 * time.Minute
+)
+
+// OpenCommandQueue loads a CommandQueue from a file, or creates an
+// empty one if the file doesn't exist.
+func OpenCommandQueue(path string) (*CommandQueue, error) {
+	q := &CommandQueue{
+		MaxAttempts: DefaultQueueMaxAttempts,
+		Wake:        true,
+		WakeTimeout: DefaultQueueWakeTimeout,
+		path:        path,
+	}
+
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &q.commands)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// save writes the queue to its file.  Like SaveCachedToken, it writes
+// a new file and moves it atomically into place.  Must be called with
+// q.mu held.
+func (q *CommandQueue) save() error {
+	body, err := json.MarshalIndent(q.commands, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, body)
+}
+
+// sensitive returns true if a command payload has any parameters that
+// the audit log would redact.
+func sensitive(payload []byte) bool {
+	var params map[string]json.RawMessage
+	if json.Unmarshal(payload, &params) != nil {
+		return false
+	}
+	for k := range params {
+		if redactedParams[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueue adds a command for a vehicle to the queue.  The params are
+// as for Client.PostCommand.  If ttl is non-zero, the command expires
+// (and will not be delivered) if it can't be delivered within that
+// time.  Commands with a PIN or password parameter are refused with
+// ErrSensitiveParams.
+func (q *CommandQueue) Enqueue(ids string, command string, params interface{}, ttl time.Duration) (*QueuedCommand, error) {
+	var payload json.RawMessage
+	if params != nil {
+		var err error
+		payload, err = json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		if sensitive(payload) {
+			return nil, fmt.Errorf("%s: %w", command, ErrSensitiveParams)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	q.seq++
+	qc := &QueuedCommand{
+		ID:       fmt.Sprintf("%d-%d", now.UnixNano(), q.seq),
+		Vehicle:  ids,
+		Command:  command,
+		Params:   payload,
+		Enqueued: now,
+		Status:   QueuePending,
+	}
+	if ttl > 0 {
+		qc.Expires = now.Add(ttl)
+	}
+	q.commands = append(q.commands, qc)
+
+	err := q.save()
+	if err != nil {
+		return nil, err
+	}
+	ret := *qc
+	return &ret, nil
+}
+
+// Commands returns a copy of all commands in the queue (including
+// finished ones) for the given vehicle, or for all vehicles if ids
+// is empty.
+func (q *CommandQueue) Commands(ids string) []QueuedCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var cmds []QueuedCommand
+	for _, qc := range q.commands {
+		if ids == "" || qc.Vehicle == ids {
+			cmds = append(cmds, *qc)
+		}
+	}
+	return cmds
+}
+
+// pending returns the pending commands for a vehicle, in order.
+// Must be called with q.mu held.
+func (q *CommandQueue) pending(ids string) []*QueuedCommand {
+	var cmds []*QueuedCommand
+	for _, qc := range q.commands {
+		if qc.Vehicle == ids && qc.Status == QueuePending {
+			cmds = append(cmds, qc)
+		}
+	}
+	return cmds
+}
+
+// Prune removes finished commands that finished before the given time.
+func (q *CommandQueue) Prune(before time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var kept []*QueuedCommand
+	for _, qc := range q.commands {
+		if qc.Status == QueuePending || !qc.Finished.Before(before) {
+			kept = append(kept, qc)
+		}
+	}
+	q.commands = kept
+	return q.save()
+}
+
+// vehicles returns the IDs of vehicles with pending commands.
+func (q *CommandQueue) vehicles() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ids []string
+	seen := make(map[string]bool)
+	for _, qc := range q.commands {
+		if qc.Status == QueuePending && !seen[qc.Vehicle] {
+			seen[qc.Vehicle] = true
+			ids = append(ids, qc.Vehicle)
+		}
+	}
+	return ids
+}
+
+// expire marks pending commands for a vehicle that have passed their
+// expiry time, and returns true if any are still pending.
+func (q *CommandQueue) expire(ids string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	changed := false
+	left := false
+	for _, qc := range q.pending(ids) {
+		if qc.expired(now) {
+			qc.finish(QueueExpired, nil, nil)
+			changed = true
+		} else {
+			left = true
+		}
+	}
+	if changed {
+		return left, q.save()
+	}
+	return left, nil
+}
+
+// Deliver attempts to deliver the pending commands for a vehicle, in
+// order.  If the vehicle isn't online, it is woken first (or, if
+// q.Wake is false, delivery is put off until it comes online by
+// itself).  If a command fails with a retryable error, delivery stops
+// so that later commands aren't delivered out of order; the next call
+// to Deliver will try again.  If ctx is done during delivery, the
+// command being sent stays pending (and the attempt isn't counted).
+// Returns an error if the vehicle couldn't be reached; the outcome of
+// each command is recorded in the queue.
+// Deliver must not be called concurrently for the same vehicle.
+func (q *CommandQueue) Deliver(ctx context.Context, c *Client, ids string) error {
+	left, err := q.expire(ids)
+	if err != nil || !left {
+		return err
+	}
+
+	// Make sure the vehicle is awake
+	if q.Wake {
+		wctx, cancel := context.WithTimeout(ctx, q.WakeTimeout)
+		_, err = c.WakeUpAndWait(wctx, ids)
+		cancel()
+		if err != nil {
+			return err
+		}
+	} else {
+		v, err := c.findVehicle(ctx, ids)
+		if err != nil {
+			return err
+		}
+		if v.State != VehicleStateOnline {
+			return nil
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, qc := range q.pending(ids) {
+		if qc.expired(time.Now()) {
+			qc.finish(QueueExpired, nil, nil)
+			continue
+		}
+
+		// Don't hold up other users of the queue while waiting
+		// for the vehicle.
+		payload := []byte(qc.Params)
+		if len(payload) == 0 {
+			payload = []byte("{}")
+		}
+		qc.Attempts++
+		q.mu.Unlock()
+		res, err := c.sendCommand(ctx, ids, qc.Command, payload)
+		if err == nil {
+			err = res.Err()
+		}
+		q.mu.Lock()
+
+		// If we gave up (for example, because the daemon is
+		// stopping), the command may never have reached the
+		// vehicle, so leave it pending as if it hadn't been tried.
+		if err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			qc.Attempts--
+			qc.Error = err.Error()
+			serr := q.save()
+			if serr != nil {
+				return serr
+			}
+			return err
+		}
+
+		switch {
+		case err == nil:
+			qc.finish(QueueDelivered, res, nil)
+		case IsRetryable(err) && qc.Attempts < q.MaxAttempts:
+			qc.Error = err.Error()
+			return q.save()
+		default:
+			qc.finish(QueueFailed, res, err)
+		}
+	}
+
+	return q.save()
+}
+
+// Run delivers queued commands for all vehicles, checking for pending
+// commands at the given interval, until ctx is done.  Delivery errors
+// are logged with the log package's standard logger.
+func (q *CommandQueue) Run(ctx context.Context, c *Client, interval time.Duration) error {
+	for {
+		for _, ids := range q.vehicles() {
+			// Errors reaching a vehicle are expected, we'll
+			// just try again next time around.
+			err := q.Deliver(ctx, c, ids)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("queue: vehicle %s: %v\n", ids, err)
+			}
+		}
+
+		err := sleepContext(ctx, interval)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,192 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// commandServer is a fake owner API with one online vehicle, "1",
+// whose command requests are answered by handler (the nth request,
+// counting from 0).
+type commandServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newCommandServer(handler func(n int, w http.ResponseWriter, r *http.Request)) *commandServer {
+	s := &commandServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/1/vehicles" {
+			w.Write([]byte(`{"response":[{"id_s":"1","state":"online"}],"count":1}`))
+			return
+		}
+		const prefix = "/api/1/vehicles/1/command/"
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.Lock()
+		n := len(s.commands)
+		s.commands = append(s.commands, strings.TrimPrefix(r.URL.Path, prefix))
+		s.mu.Unlock()
+		handler(n, w, r)
+	}))
+	return s
+}
+
+func (s *commandServer) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+func commandOK(n int, w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(`{"response":{"result":true,"reason":""}}`))
+}
+
+// testQueue returns an empty CommandQueue in a temporary directory,
+// which the caller must remove, and that doesn't wake vehicles.
+func testQueue(t *testing.T) (*CommandQueue, string) {
+	dir, err := ioutil.TempDir("", "gotesla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := OpenCommandQueue(filepath.Join(dir, "queue.json"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	q.Wake = false
+	return q, dir
+}
+
+func TestQueueCancelDuringSend(t *testing.T) {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	s := newCommandServer(func(n int, w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+	})
+	defer s.Close()
+	defer close(release)
+	q, dir := testQueue(t)
+	defer os.RemoveAll(dir)
+	c := NewClient(WithBaseURL(s.URL), WithRetryPolicy(NoRetryPolicy))
+
+	_, err := q.Enqueue("1", "door_lock", nil, 0)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+	err = q.Deliver(ctx, c, "1")
+	if err == nil {
+		t.Error("Deliver: no error after cancel")
+	}
+
+	// The command is still pending, in the saved queue too
+	q, err = OpenCommandQueue(q.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmds := q.Commands("1")
+	if len(cmds) != 1 || cmds[0].Status != QueuePending || cmds[0].Attempts != 0 {
+		t.Errorf("got commands %+v", cmds)
+	}
+}
+
+func TestQueueExpiry(t *testing.T) {
+	s := newCommandServer(commandOK)
+	defer s.Close()
+	q, dir := testQueue(t)
+	defer os.RemoveAll(dir)
+	c := NewClient(WithBaseURL(s.URL), WithRetryPolicy(NoRetryPolicy))
+
+	_, err := q.Enqueue("1", "door_lock", nil, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	_, err = q.Enqueue("1", "door_unlock", nil, 0)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	err = q.Deliver(context.Background(), c, "1")
+	if err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	cmds := q.Commands("1")
+	if len(cmds) != 2 || cmds[0].Status != QueueExpired || cmds[1].Status != QueueDelivered {
+		t.Errorf("got commands %+v", cmds)
+	}
+	if sent := s.sent(); len(sent) != 1 || sent[0] != "door_unlock" {
+		t.Errorf("sent %v", sent)
+	}
+}
+
+func TestQueueOrderAfterRetry(t *testing.T) {
+	s := newCommandServer(func(n int, w http.ResponseWriter, r *http.Request) {
+		if n == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		commandOK(n, w, r)
+	})
+	defer s.Close()
+	q, dir := testQueue(t)
+	defer os.RemoveAll(dir)
+	c := NewClient(WithBaseURL(s.URL), WithRetryPolicy(NoRetryPolicy))
+
+	for _, command := range []string{"charge_start", "charge_stop"} {
+		_, err := q.Enqueue("1", command, nil, 0)
+		if err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	// The first command fails with a retryable error, so the
+	// second isn't sent before it
+	err := q.Deliver(context.Background(), c, "1")
+	if err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	cmds := q.Commands("1")
+	if cmds[0].Status != QueuePending || cmds[0].Attempts != 1 || cmds[1].Status != QueuePending {
+		t.Errorf("after first Deliver: got commands %+v", cmds)
+	}
+
+	err = q.Deliver(context.Background(), c, "1")
+	if err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	cmds = q.Commands("1")
+	if cmds[0].Status != QueueDelivered || cmds[1].Status != QueueDelivered {
+		t.Errorf("after second Deliver: got commands %+v", cmds)
+	}
+	want := []string{"charge_start", "charge_start", "charge_stop"}
+	if sent := s.sent(); strings.Join(sent, " ") != strings.Join(want, " ") {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+}
@@ -0,0 +1,187 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+//
+// Automatically refreshing tokens
+//
+
+// DefaultRefreshMargin is how long before a token expires that a
+// RefreshingTokenSource refreshes it.
+const DefaultRefreshMargin = 24 * time.Hour
+
+// A tokenRefresher is a TokenSource that can replace a token that
+// the server has rejected.
+type tokenRefresher interface {
+	Refresh(ctx context.Context, stale *Token) (*Token, error)
+}
+
+// refreshCall is a refresh in progress, which other callers can wait for.
+type refreshCall struct {
+	done chan struct{}
+	t    *Token
+	err  error
+}
+
+// A RefreshingTokenSource is a TokenSource that refreshes its token
+// before it expires, saving each new token to a TokenStore.  Concurrent
+// requests for a refresh are coalesced into a single call to the Tesla
+// servers.  When used by a Client, a request that fails with a 401
+// (Unauthorized) causes one refresh, after which the request is retried.
+//
+// A RefreshingTokenSource is safe for concurrent use, and can be
+// shared by multiple Clients.
+type RefreshingTokenSource struct {
+	// Margin is how long before expiry the token is refreshed.
+	// Tokens with short lifetimes are refreshed halfway through
+	// their lifetime instead.
+	Margin time.Duration
+
+	client *Client
+	store  TokenStore
+	mu     sync.Mutex
+	t      *Token
+	call   *refreshCall
+}
+
+// NewRefreshingTokenSource returns a RefreshingTokenSource that starts
+// with token t (or if t is nil, the token loaded from store), and
+// refreshes it using Client c.  New tokens are saved to store, unless
+// it is nil.
+func NewRefreshingTokenSource(c *Client, store TokenStore, t *Token) *RefreshingTokenSource {
+	return &RefreshingTokenSource{
+		Margin: DefaultRefreshMargin,
+		client: c,
+		store:  store,
+		t:      t,
+	}
+}
+
+// WithAutoRefresh authenticates requests with a RefreshingTokenSource
+// that loads the token from store, refreshes it using this Client, and
+// saves new tokens back to store.
+func WithAutoRefresh(store TokenStore) ClientOption {
+	return func(c *Client) {
+		c.tokenSource = NewRefreshingTokenSource(c, store, nil)
+	}
+}
+
+// current returns the current token, loading it if necessary.  Must be
+// called with s.mu held.
+func (s *RefreshingTokenSource) current() (*Token, error) {
+	if s.t == nil {
+		if s.store == nil {
+			return nil, errors.New("no token")
+		}
+		t, err := s.store.Load()
+		if err != nil {
+			return nil, err
+		}
+		s.t = t
+	}
+	return s.t, nil
+}
+
+// needsRefresh returns true if a token should be refreshed now.
+func (s *RefreshingTokenSource) needsRefresh(t *Token) bool {
+	if t.RefreshToken == "" && t.SSORefreshToken == "" {
+		// Nothing we can do, just use it as long as it works
+		return false
+	}
+	margin := s.Margin
+	if half := time.Duration(t.ExpiresIn) * time.Second / 2; half < margin {
+		margin = half
+	}
+	return TokenLifetime(t) < margin
+}
+
+// Token returns a valid token, refreshing it first if it is about to
+// expire.  If the refresh fails, the old token is returned as long as
+// it hasn't actually expired.
+func (s *RefreshingTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	t, err := s.current()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if !s.needsRefresh(t) {
+		return t, nil
+	}
+
+	t2, err := s.Refresh(ctx, t)
+	if t2 == nil && TokenLifetime(t) > 0 {
+		return t, nil
+	}
+	return t2, err
+}
+
+// Refresh refreshes the token, unless it has already been replaced
+// since stale was handed out, in which case the replacement is
+// returned.  If another refresh is already in progress, Refresh waits
+// for its result.  If the new token couldn't be saved, it is returned
+// along with the error (and used from then on).
+func (s *RefreshingTokenSource) Refresh(ctx context.Context, stale *Token) (*Token, error) {
+	s.mu.Lock()
+	t, err := s.current()
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if stale != nil && t.AccessToken != stale.AccessToken {
+		s.mu.Unlock()
+		return t, nil
+	}
+	if call := s.call; call != nil {
+		s.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.t, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	s.call = call
+	s.mu.Unlock()
+
+	call.t, call.err = s.refresh(ctx, t)
+
+	s.mu.Lock()
+	s.call = nil
+	if call.t != nil {
+		s.t = call.t
+	}
+	s.mu.Unlock()
+	close(call.done)
+
+	return call.t, call.err
+}
+
+// refresh gets a new token and saves it.
+func (s *RefreshingTokenSource) refresh(ctx context.Context, t *Token) (*Token, error) {
+	t2, err := s.client.RefreshToken(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	if s.store != nil {
+		err = s.store.Save(t2)
+		if errors.Is(err, ErrReadOnlyStore) {
+			err = nil
+		}
+	}
+	return t2, err
+}
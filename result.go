@@ -0,0 +1,257 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//
+// Command results
+//
+
+// CommandOutcome classifies the result of a vehicle command.
+type CommandOutcome int
+
+// CommandOutcome values
+const (
+	CommandSucceeded        CommandOutcome = iota // Command was accepted
+	CommandAlreadySatisfied                       // Vehicle was already in the desired state
+	CommandRetryable                              // Command failed, but might work later
+	CommandFatal                                  // Command failed, and retrying won't help
+)
+
+// String returns a human-readable CommandOutcome.
+func (o CommandOutcome) String() string {
+	switch o {
+	case CommandSucceeded:
+		return "succeeded"
+	case CommandAlreadySatisfied:
+		return "already satisfied"
+	case CommandRetryable:
+		return "retryable"
+	case CommandFatal:
+		return "fatal"
+	}
+	return "unknown"
+}
+
+// commandReasons maps the Reason of a failed command to its outcome.
+// Reasons are matched by prefix, since some have extra text appended,
+// so the list is ordered longest first, and the most specific prefix
+// wins.  Reasons that aren't listed are fatal.
+var commandReasons = []struct {
+	prefix  string
+	outcome CommandOutcome
+}{
+	{"could_not_wake_buses", CommandRetryable},
+	{"vehicle unavailable", CommandRetryable},
+	{"vehicle_unavailable", CommandRetryable},
+	{"not_charging", CommandAlreadySatisfied},
+	{"user_present", CommandFatal},
+	{"already_set", CommandAlreadySatisfied},
+	{"already_off", CommandAlreadySatisfied},
+	{"is_charging", CommandAlreadySatisfied},
+	{"already_on", CommandAlreadySatisfied},
+	{"complete", CommandAlreadySatisfied},
+	{"timeout", CommandRetryable},
+	{"busy", CommandRetryable},
+}
+
+// Outcome classifies a CommandResult.
+func (r *CommandResult) Outcome() CommandOutcome {
+	if r.Result {
+		return CommandSucceeded
+	}
+	for _, cr := range commandReasons {
+		if strings.HasPrefix(r.Reason, cr.prefix) {
+			return cr.outcome
+		}
+	}
+	return CommandFatal
+}
+
+// A CommandError describes a command that the vehicle did not carry out.
+type CommandError struct {
+	Command string
+	Reason  string
+	Outcome CommandOutcome
+}
+
+// Error returns a string representation of a CommandError.
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command %s failed (%s): %s", e.Command, e.Outcome, e.Reason)
+}
+
+// Err returns nil if a command succeeded or the vehicle was already
+// in the desired state, otherwise a *CommandError.
+func (r *CommandResult) Err() error {
+	switch o := r.Outcome(); o {
+	case CommandSucceeded, CommandAlreadySatisfied:
+		return nil
+	default:
+		return &CommandError{Command: r.Command, Reason: r.Reason, Outcome: o}
+	}
+}
+
+// IsRetryable returns true if err is a network error, a 408 (vehicle
+// asleep) or retryable APIError, or a CommandError with a retryable
+// outcome.  That is, the same command might work if tried later.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var ce *CommandError
+	if errors.As(err, &ce) {
+		return ce.Outcome == CommandRetryable
+	}
+	if IsVehicleAsleep(err) {
+		return true
+	}
+
+	// Otherwise use the same rules as the request layer, ignoring
+	// the attempt count and request method.
+	p := RetryPolicy{MaxAttempts: 2, RetryNonIdempotent: true}
+	return p.retryable("POST", 1, err)
+}
+
+//
+// Confirming the effect of commands
+//
+
+// ErrNotConfirmed is returned (wrapped) when the effect of a command
+// hasn't been observed by the time the confirmation times out.
+var ErrNotConfirmed = errors.New("command effect not confirmed")
+
+// confirmPollInterval is the interval between state reads while
+// confirming a command.
+var confirmPollInterval = 2 * time.Second
+
+// A Confirmation reads some part of a vehicle's state, and returns
+// true if it shows the effect of a command.
+type Confirmation func(ctx context.Context, c *Client, ids string) (bool, error)
+
+// ConfirmChargeState returns a Confirmation that applies pred to the
+// vehicle's ChargeState.
+func ConfirmChargeState(pred func(*ChargeState) bool) Confirmation {
+	return func(ctx context.Context, c *Client, ids string) (bool, error) {
+		cs, err := c.GetChargeState(ctx, ids)
+		if err != nil {
+			return false, err
+		}
+		return pred(cs), nil
+	}
+}
+
+// ConfirmClimateState returns a Confirmation that applies pred to the
+// vehicle's ClimateState.
+func ConfirmClimateState(pred func(*ClimateState) bool) Confirmation {
+	return func(ctx context.Context, c *Client, ids string) (bool, error) {
+		cls, err := c.GetClimateState(ctx, ids)
+		if err != nil {
+			return false, err
+		}
+		return pred(cls), nil
+	}
+}
+
+// ConfirmVehicleState returns a Confirmation that applies pred to the
+// vehicle's VehicleState.
+func ConfirmVehicleState(pred func(*VehicleState) bool) Confirmation {
+	return func(ctx context.Context, c *Client, ids string) (bool, error) {
+		vs, err := c.GetVehicleState(ctx, ids)
+		if err != nil {
+			return false, err
+		}
+		return pred(vs), nil
+	}
+}
+
+// ConfirmLocked confirms that the doors are locked (or unlocked).
+func ConfirmLocked(locked bool) Confirmation {
+	return ConfirmVehicleState(func(vs *VehicleState) bool {
+		return vs.Locked == locked
+	})
+}
+
+// ConfirmSentryMode confirms that Sentry Mode is on (or off).
+func ConfirmSentryMode(on bool) Confirmation {
+	return ConfirmVehicleState(func(vs *VehicleState) bool {
+		return vs.SentryMode == on
+	})
+}
+
+// ConfirmCharging confirms that the vehicle is charging (or not).
+func ConfirmCharging(charging bool) Confirmation {
+	return ConfirmChargeState(func(cs *ChargeState) bool {
+		return (cs.ChargingState == "Charging" || cs.ChargingState == "Starting") == charging
+	})
+}
+
+// ConfirmChargeLimit confirms that the charge limit has been set.
+func ConfirmChargeLimit(percent int) Confirmation {
+	return ConfirmChargeState(func(cs *ChargeState) bool {
+		return cs.ChargeLimitSoc == percent
+	})
+}
+
+// ConfirmClimateOn confirms that the climate control is on (or off).
+func ConfirmClimateOn(on bool) Confirmation {
+	return ConfirmClimateState(func(cls *ClimateState) bool {
+		return cls.IsClimateOn == on
+	})
+}
+
+// DoAndConfirm runs a command, and if it succeeds, polls the vehicle's
+// state with confirm until the effect of the command is observed or
+// the timeout expires.  If the vehicle reports that it was already
+// in the desired state, no polling is done.  The error is non-nil
+// if the command failed (see CommandResult.Err) or its effect
+// couldn't be confirmed.
+//
+// For example, to lock a vehicle and wait for the doors to show up
+// as locked:
+//
+//	res, err := c.DoAndConfirm(ctx, ids, func(ctx context.Context) (*CommandResult, error) {
+//		return c.DoorLock(ctx, ids)
+//	}, ConfirmLocked(true), 30*time.Second)
+func (c *Client) DoAndConfirm(ctx context.Context, ids string, cmd func(context.Context) (*CommandResult, error), confirm Confirmation, timeout time.Duration) (*CommandResult, error) {
+	res, err := cmd(ctx)
+	if err != nil {
+		return res, err
+	}
+	if err = res.Err(); err != nil {
+		return res, err
+	}
+	if res.Outcome() == CommandAlreadySatisfied {
+		return res, nil
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		ok, err := confirm(cctx, c, ids)
+		if err == nil && ok {
+			return res, nil
+		}
+
+		// Keep trying after errors, the vehicle might be
+		// busy carrying out the command.
+		if sleepContext(cctx, confirmPollInterval) != nil {
+			if err != nil {
+				return res, fmt.Errorf("command %s: %w: %v", res.Command, ErrNotConfirmed, err)
+			}
+			return res, fmt.Errorf("command %s: %w", res.Command, ErrNotConfirmed)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// A RetryPolicy controls how a Client retries failed requests.
+// Requests are retried after network errors and after responses
+// with a status of 429 (Too Many Requests), 502 (Bad Gateway),
+// 503 (Service Unavailable) or 504 (Gateway Timeout).  If the server
+// sends a Retry-After header, it is used in place of the computed
+// backoff.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made for a
+	// request, including the first one.  A value of 1 or less
+	// disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.  It
+	// doubles with each subsequent retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff limits the delay between attempts.  If a server
+	// asks us (via Retry-After) to wait longer than this, the
+	// request fails instead.
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction (0.0 to 1.0) of each computed backoff
+	// that is randomized, so that clients don't retry in lockstep.
+	Jitter float64
+
+	// RetryNonIdempotent allows retries of requests other than GET.
+	// Vehicle commands are POST requests and are not retried by
+	// default, since they might have taken effect even though the
+	// response was lost.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by a new Client.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 1 * time.Second,
+	MaxBackoff:     30 * time.Second,
+	Jitter:         0.5,
+}
+
+// NoRetryPolicy disables retries.
+var NoRetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+// WithRetryPolicy sets the RetryPolicy of a Client.  The default is
+// DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retryPolicy = p
+	}
+}
+
+// retryable returns true if a request that failed with err on the
+// given attempt (starting at 1) should be tried again.
+func (p *RetryPolicy) retryable(method string, attempt int, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if method != "GET" && !p.RetryNonIdempotent {
+		return false
+	}
+
+	// Don't bother if the caller has given up
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var e *APIError
+	if !errors.As(err, &e) {
+		// Not an HTTP status, probably a network error
+		return true
+	}
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before the next attempt, after the given
+// attempt (starting at 1) failed with err.  A negative return value
+// means that the server wants us to wait too long, and the request
+// should not be retried.
+func (p *RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var e *APIError
+	if errors.As(err, &e) && e.RetryAfter > 0 {
+		if p.MaxBackoff > 0 && e.RetryAfter > p.MaxBackoff {
+			return -1
+		}
+		return e.RetryAfter
+	}
+
+	d := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d > p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(p.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// sleepContext waits for the given duration, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// doWithRetry sends the request made by newReq, retrying according to
+// the Client's RetryPolicy.  newReq is called once per attempt, since
+// a request body can only be read once.  Returns the body of a 200 OK
+// response, or an error (an *APIError for any other HTTP status).
+func (c *Client) doWithRetry(ctx context.Context, method string, endpoint string, newReq func() (*http.Request, error)) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := c.doOnce(req, method, endpoint)
+		if err == nil {
+			return body, nil
+		}
+
+		if !c.retryPolicy.retryable(method, attempt, err) {
+			return nil, err
+		}
+		wait := c.retryPolicy.backoff(attempt, err)
+		if wait < 0 {
+			return nil, err
+		}
+		if sleepContext(ctx, wait) != nil {
+			// Report the error from the request, not the context
+			return nil, err
+		}
+	}
+}
+
+// doOnce makes a single attempt at an HTTP request.
+func (c *Client) doOnce(req *http.Request, method string, endpoint string) ([]byte, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Try to handle certain types of HTTP status codes
+	switch resp.StatusCode {
+	case http.StatusOK:
+		/* break */
+	default:
+		return nil, newAPIError(resp, method, endpoint, body)
+	}
+
+	return body, nil
+}
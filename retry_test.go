@@ -0,0 +1,190 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedServer answers successive requests with the given statuses
+// (and Retry-After values, if non-empty), then with 200 OK.  The
+// caller must Close it.
+type scriptedServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests int
+}
+
+func newScriptedServer(statuses []int, retryAfter []string) *scriptedServer {
+	s := &scriptedServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		n := s.requests
+		s.requests++
+		s.mu.Unlock()
+
+		if n < len(statuses) {
+			if n < len(retryAfter) && retryAfter[n] != "" {
+				w.Header().Set("Retry-After", retryAfter[n])
+			}
+			w.WriteHeader(statuses[n])
+			return
+		}
+		w.Write([]byte(`{"response":"ok"}`))
+	}))
+	return s
+}
+
+func (s *scriptedServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// fastRetryPolicy retries quickly, so the tests don't take long.
+var fastRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     10 * time.Millisecond,
+}
+
+func TestRetrySucceedsAfterFailures(t *testing.T) {
+	s := newScriptedServer([]int{503, 502}, nil)
+	defer s.Close()
+	c := NewClient(WithBaseURL(s.URL), WithRetryPolicy(fastRetryPolicy))
+
+	_, err := c.GetTesla(context.Background(), "/api/1/vehicles")
+	if err != nil {
+		t.Fatalf("GetTesla: %v", err)
+	}
+	if n := s.count(); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	s := newScriptedServer([]int{503, 503, 503, 503}, nil)
+	defer s.Close()
+	c := NewClient(WithBaseURL(s.URL), WithRetryPolicy(fastRetryPolicy))
+
+	_, err := c.GetTesla(context.Background(), "/api/1/vehicles")
+	if statusCode(err) != http.StatusServiceUnavailable {
+		t.Fatalf("got error %v, want 503", err)
+	}
+	if n := s.count(); n != fastRetryPolicy.MaxAttempts {
+		t.Errorf("got %d requests, want %d", n, fastRetryPolicy.MaxAttempts)
+	}
+}
+
+func TestRetryNotForClientErrors(t *testing.T) {
+	s := newScriptedServer([]int{404}, nil)
+	defer s.Close()
+	c := NewClient(WithBaseURL(s.URL), WithRetryPolicy(fastRetryPolicy))
+
+	_, err := c.GetTesla(context.Background(), "/api/1/vehicles")
+	if statusCode(err) != http.StatusNotFound {
+		t.Fatalf("got error %v, want 404", err)
+	}
+	if n := s.count(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestRetryAfterHonored(t *testing.T) {
+	s := newScriptedServer([]int{429}, []string{"1"})
+	defer s.Close()
+	p := fastRetryPolicy
+	p.MaxBackoff = 5 * time.Second
+	c := NewClient(WithBaseURL(s.URL), WithRetryPolicy(p))
+
+	start := time.Now()
+	_, err := c.GetTesla(context.Background(), "/api/1/vehicles")
+	if err != nil {
+		t.Fatalf("GetTesla: %v", err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("retried after %v, want at least 1s", d)
+	}
+	if n := s.count(); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	s := newScriptedServer([]int{429}, []string{"3600"})
+	defer s.Close()
+	c := NewClient(WithBaseURL(s.URL), WithRetryPolicy(fastRetryPolicy))
+
+	start := time.Now()
+	_, err := c.GetTesla(context.Background(), "/api/1/vehicles")
+	if statusCode(err) != http.StatusTooManyRequests {
+		t.Fatalf("got error %v, want 429", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("gave up after %v, want immediately", d)
+	}
+	if n := s.count(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	tests := []struct {
+		name     string
+		allow    bool
+		requests int
+		wantErr  bool
+	}{
+		{"default", false, 1, true},
+		{"RetryNonIdempotent", true, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScriptedServer([]int{503}, nil)
+			defer s.Close()
+			p := fastRetryPolicy
+			p.RetryNonIdempotent = tt.allow
+			c := NewClient(WithBaseURL(s.URL), WithRetryPolicy(p))
+
+			_, err := c.PostTesla(context.Background(), "/api/1/vehicles/1/command/honk_horn", []byte("{}"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PostTesla error %v, want error %v", err, tt.wantErr)
+			}
+			if n := s.count(); n != tt.requests {
+				t.Errorf("got %d requests, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 11, 20, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-1", 0},
+		{"Sat, 20 Nov 2021 12:00:30 GMT", 30 * time.Second},
+		{"Sat, 20 Nov 2021 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//
+// Token revocation
+//
+
+// A RevokeError reports which steps of revoking (and possibly
+// deleting) a token failed.  A nil error for a step means it succeeded.
+type RevokeError struct {
+	AccessErr  error // revoking the access token
+	RefreshErr error // revoking the refresh token(s)
+	DeleteErr  error // deleting the token from its TokenStore
+
+	refreshed bool // there was a refresh token to revoke
+	deleted   bool // deletion was attempted
+}
+
+// Error returns a string describing the outcome of every step, so that
+// it's clear what was and wasn't done.
+func (e *RevokeError) Error() string {
+	var parts []string
+	step := func(what string, err error) {
+		if err != nil {
+			parts = append(parts, what+" failed: "+err.Error())
+		} else {
+			parts = append(parts, what+" succeeded")
+		}
+	}
+	if e.refreshed {
+		step("revoking refresh token", e.RefreshErr)
+	} else {
+		parts = append(parts, "no refresh token to revoke")
+	}
+	step("revoking access token", e.AccessErr)
+	if e.deleted {
+		step("deleting cached token", e.DeleteErr)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Unwrap returns the first error.
+func (e *RevokeError) Unwrap() error {
+	for _, err := range []error{e.RefreshErr, e.AccessErr, e.DeleteErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failed returns true if any step failed.
+func (e *RevokeError) failed() bool {
+	return e.AccessErr != nil || e.RefreshErr != nil || e.DeleteErr != nil
+}
+
+// revokeOwner revokes a token with the owner API.
+func (c *Client) revokeOwner(ctx context.Context, t *Token, token string) error {
+	payload, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return err
+	}
+	_, err = c.ownerAPI().doTesla(ctx, "POST", "/oauth/revoke", payload, t)
+	return err
+}
+
+// revokeSSO revokes an SSO refresh token.
+func (c *Client) revokeSSO(ctx context.Context, token string) error {
+	s, err := c.newSSOSession(url.Values{})
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "refresh_token")
+	resp, body, err := s.do(ctx, "POST", c.ssoBaseURL+"/oauth2/v3/revoke", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp, "POST", "/oauth2/v3/revoke", body)
+	}
+	return nil
+}
+
+// revoke does the revocation steps, recording their results in e.
+// The refresh tokens are revoked first, while the access token that
+// authenticates the owner API request is still good.
+func (c *Client) revoke(ctx context.Context, t *Token, e *RevokeError) {
+	if t.SSORefreshToken != "" {
+		e.refreshed = true
+		e.RefreshErr = c.revokeSSO(ctx, t.SSORefreshToken)
+	}
+	if t.RefreshToken != "" {
+		e.refreshed = true
+		if err := c.revokeOwner(ctx, t, t.RefreshToken); e.RefreshErr == nil {
+			e.RefreshErr = err
+		}
+	}
+	e.AccessErr = c.revokeOwner(ctx, t, t.AccessToken)
+}
+
+// RevokeToken revokes a Token's access and refresh tokens at Tesla, so
+// that they can no longer be used.  If any step fails, the error is a
+// *RevokeError.
+func (c *Client) RevokeToken(ctx context.Context, t *Token) error {
+	var e RevokeError
+	c.revoke(ctx, t, &e)
+	if e.failed() {
+		return &e
+	}
+	return nil
+}
+
+// RevokeToken is the package-level equivalent of Client.RevokeToken.
+func RevokeToken(client *http.Client, t *Token) error {
+	return newLegacyClient(client, nil).RevokeToken(context.Background(), t)
+}
+
+// Revoke logs out:  it revokes the token in a TokenStore at Tesla, and
+// then deletes it from the store.  The token is deleted even if it
+// couldn't be revoked.  If any step fails, the error is a *RevokeError,
+// which says which steps succeeded.
+func (c *Client) Revoke(ctx context.Context, store TokenStore) error {
+	t, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	e := RevokeError{deleted: true}
+	c.revoke(ctx, t, &e)
+	e.DeleteErr = store.Delete()
+	if e.failed() {
+		return &e
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRevoker is a fake owner API and SSO server that accepts
+// revocations, and records them in order.  Once an access token is
+// revoked, requests authenticated with it fail.
+type fakeRevoker struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	revoked []string
+}
+
+func newFakeRevoker() *fakeRevoker {
+	f := &fakeRevoker{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/revoke", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for _, tok := range f.revoked {
+			if tok == bearer {
+				http.Error(w, "token revoked", http.StatusUnauthorized)
+				return
+			}
+		}
+		f.revoked = append(f.revoked, req["token"])
+	})
+	mux.HandleFunc("/oauth2/v3/revoke", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.revoked = append(f.revoked, r.PostForm.Get("token"))
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func TestRevokeToken(t *testing.T) {
+	f := newFakeRevoker()
+	defer f.Close()
+	c := NewClient(WithBaseURL(f.URL), WithSSOBaseURL(f.URL), WithRetryPolicy(NoRetryPolicy))
+
+	tok := &Token{AccessToken: "access", RefreshToken: "refresh", SSORefreshToken: "sso-refresh"}
+	err := c.RevokeToken(context.Background(), tok)
+	if err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	want := []string{"sso-refresh", "refresh", "access"}
+	if strings.Join(f.revoked, " ") != strings.Join(want, " ") {
+		t.Errorf("revoked %v, want %v", f.revoked, want)
+	}
+}
+
+func TestRevokeTokenNoRefresh(t *testing.T) {
+	f := newFakeRevoker()
+	defer f.Close()
+	c := NewClient(WithBaseURL(f.URL), WithSSOBaseURL(f.URL), WithRetryPolicy(NoRetryPolicy))
+
+	// The access token is already revoked, so revoking it fails
+	f.revoked = []string{"access"}
+	err := c.RevokeToken(context.Background(), &Token{AccessToken: "access"})
+	var e *RevokeError
+	if !errors.As(err, &e) {
+		t.Fatalf("got error %v, want *RevokeError", err)
+	}
+	if e.AccessErr == nil || e.RefreshErr != nil {
+		t.Errorf("got %+v", e)
+	}
+	msg := e.Error()
+	if !strings.Contains(msg, "no refresh token") || strings.Contains(msg, "revoking refresh token succeeded") {
+		t.Errorf("got message %q", msg)
+	}
+}
+
+func TestRevokePartialFailure(t *testing.T) {
+	f := newFakeRevoker()
+	defer f.Close()
+	c := NewClient(WithBaseURL(f.URL), WithSSOBaseURL(f.URL), WithRetryPolicy(NoRetryPolicy))
+
+	dir, err := ioutil.TempDir("", "gotesla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileTokenStore(filepath.Join(dir, "token.json"))
+	err = store.Save(&Token{AccessToken: "access", RefreshToken: "refresh", SSORefreshToken: "sso-refresh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The SSO refresh token is revoked, but the owner API requests
+	// fail because the access token is already revoked
+	f.revoked = []string{"access"}
+	err = c.Revoke(context.Background(), store)
+	var e *RevokeError
+	if !errors.As(err, &e) {
+		t.Fatalf("got error %v, want *RevokeError", err)
+	}
+	if e.RefreshErr == nil || e.AccessErr == nil || e.DeleteErr != nil {
+		t.Errorf("got %+v", e)
+	}
+	if want := []string{"access", "sso-refresh"}; strings.Join(f.revoked, " ") != strings.Join(want, " ") {
+		t.Errorf("revoked %v, want %v", f.revoked, want)
+	}
+	msg := e.Error()
+	if !strings.Contains(msg, "revoking refresh token failed") || !strings.Contains(msg, "revoking access token failed") ||
+		!strings.Contains(msg, "deleting cached token succeeded") {
+		t.Errorf("got message %q", msg)
+	}
+
+	// The token is deleted anyway
+	_, err = store.Load()
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load after Revoke: got %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,136 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//
+// Scheduled charging and departure
+//
+
+// ClockTime is a time of day, expressed (as the API does) in minutes
+// after midnight, local to the vehicle.
+type ClockTime int
+
+// minutesPerDay is the number of valid ClockTime values.
+const minutesPerDay = 24 * 60
+
+// NewClockTime returns the ClockTime for a given hour and minute.
+func NewClockTime(hour int, minute int) ClockTime {
+	return ClockTime(hour*60 + minute)
+}
+
+// ClockTimeOf returns the time of day of t, in t's location.
+func ClockTimeOf(t time.Time) ClockTime {
+	return NewClockTime(t.Hour(), t.Minute())
+}
+
+// Hour returns the hour of a ClockTime.
+func (ct ClockTime) Hour() int {
+	return int(ct) / 60
+}
+
+// Minute returns the minute (within the hour) of a ClockTime.
+func (ct ClockTime) Minute() int {
+	return int(ct) % 60
+}
+
+// String returns a ClockTime in HH:MM format.
+func (ct ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", ct.Hour(), ct.Minute())
+}
+
+// Validate returns an error if a ClockTime isn't a valid time of day.
+func (ct ClockTime) Validate() error {
+	if ct < 0 || ct >= minutesPerDay {
+		return fmt.Errorf("invalid time of day %d minutes after midnight", int(ct))
+	}
+	return nil
+}
+
+// Next returns the first time at or after t that has the time of day
+// ct, in t's location.
+func (ct ClockTime) Next(t time.Time) time.Time {
+	y, m, d := t.Date()
+	next := time.Date(y, m, d, ct.Hour(), ct.Minute(), 0, 0, t.Location())
+	if next.Before(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// ScheduledCharging holds the parameters for a set_scheduled_charging
+// command.  If Enable is true, charging starts at Time.
+type ScheduledCharging struct {
+	Enable bool      `json:"enable"`
+	Time   ClockTime `json:"time"`
+}
+
+// ScheduledDeparture holds the parameters for a set_scheduled_departure
+// command.  If Enable is true, the vehicle plans to be ready at
+// DepartureTime.  Preconditioning makes sure the cabin and battery are
+// warm at that time; off-peak charging delays charging until the end
+// of the off-peak period, EndOffPeakTime, allowing for the charge to
+// complete by DepartureTime.
+type ScheduledDeparture struct {
+	Enable                      bool      `json:"enable"`
+	DepartureTime               ClockTime `json:"departure_time"`
+	PreconditioningEnabled      bool      `json:"preconditioning_enabled"`
+	PreconditioningWeekdaysOnly bool      `json:"preconditioning_weekdays_only"`
+	OffPeakChargingEnabled      bool      `json:"off_peak_charging_enabled"`
+	OffPeakChargingWeekdaysOnly bool      `json:"off_peak_charging_weekdays_only"`
+	EndOffPeakTime              ClockTime `json:"end_off_peak_time"`
+}
+
+// SetScheduledCharging turns scheduled charging on or off.
+func (c *Client) SetScheduledCharging(ctx context.Context, ids string, sc *ScheduledCharging) (*CommandResult, error) {
+	if err := sc.Time.Validate(); err != nil {
+		return nil, err
+	}
+	return c.PostCommand(ctx, ids, "set_scheduled_charging", sc)
+}
+
+// SetScheduledDeparture turns scheduled departure on or off.
+func (c *Client) SetScheduledDeparture(ctx context.Context, ids string, sd *ScheduledDeparture) (*CommandResult, error) {
+	if err := sd.DepartureTime.Validate(); err != nil {
+		return nil, fmt.Errorf("departure time: %w", err)
+	}
+	if err := sd.EndOffPeakTime.Validate(); err != nil {
+		return nil, fmt.Errorf("end of off-peak time: %w", err)
+	}
+	return c.PostCommand(ctx, ids, "set_scheduled_departure", sd)
+}
+
+// ScheduledChargingStart returns the time scheduled charging is due
+// to start, and true if scheduled charging is pending.
+func (cs *ChargeState) ScheduledChargingStart() (time.Time, bool) {
+	if !cs.ScheduledChargingPending || cs.ScheduledChargingStartTime == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(cs.ScheduledChargingStartTime), 0), true
+}
+
+// ScheduledDeparture returns the scheduled departure time, and true
+// if scheduled departure is enabled.
+func (cs *ChargeState) ScheduledDeparture() (time.Time, bool) {
+	if cs.ScheduledChargingMode != "DepartBy" || cs.ScheduledDepartureTime == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(cs.ScheduledDepartureTime), 0), true
+}
+
+// OffPeakHoursEnd returns the end of the off-peak charging period,
+// and true if off-peak charging is enabled.
+func (cs *ChargeState) OffPeakHoursEnd() (ClockTime, bool) {
+	return ClockTime(cs.OffPeakHoursEndTime), cs.OffPeakChargingEnabled
+}
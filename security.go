@@ -0,0 +1,140 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//
+// Security-mode commands
+//
+
+// A PIN is a four-digit code used to control valet mode and speed
+// limit mode.  Its String and GoString methods redact the value, so
+// that a PIN doesn't end up in a log or an error message by accident.
+// It is only sent in the clear in the body of a command request.
+type PIN string
+
+// redactedPIN is printed in place of a PIN.
+const redactedPIN = "****"
+
+// String returns a redacted representation of a PIN.
+func (p PIN) String() string {
+	return redactedPIN
+}
+
+// GoString returns a redacted representation of a PIN, for %#v.
+func (p PIN) GoString() string {
+	return `"` + redactedPIN + `"`
+}
+
+// Validate returns an error if a PIN isn't four digits.  The error
+// doesn't contain the PIN.
+func (p PIN) Validate() error {
+	if len(p) != 4 {
+		return errors.New("PIN must be four digits")
+	}
+	for _, r := range p {
+		if r < '0' || r > '9' {
+			return errors.New("PIN must be four digits")
+		}
+	}
+	return nil
+}
+
+// pinParams are the parameters for the speed limit commands that
+// need a PIN.
+type pinParams struct {
+	PIN PIN `json:"pin"`
+}
+
+// CheckLimit returns an error if a speed limit (in MPH) is outside the
+// range supported by the vehicle.
+func (slm *VehicleStateSpeedLimitMode) CheckLimit(mph float64) error {
+	if mph < float64(slm.MinLimitMph) || mph > float64(slm.MaxLimitMph) {
+		return fmt.Errorf("speed limit %.0f MPH out of range [%d, %d]", mph, slm.MinLimitMph, slm.MaxLimitMph)
+	}
+	return nil
+}
+
+// SetSentryMode turns Sentry Mode on or off.
+func (c *Client) SetSentryMode(ctx context.Context, ids string, on bool) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "set_sentry_mode", &onParams{on})
+}
+
+// SetValetMode turns valet mode on or off.  If a PIN has already been
+// set (VehicleState.ValetPinNeeded is false), pin may be empty;
+// otherwise the new PIN is set when valet mode is turned on.
+func (c *Client) SetValetMode(ctx context.Context, ids string, on bool, pin PIN) (*CommandResult, error) {
+	if pin != "" {
+		if err := pin.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	params := struct {
+		On       bool `json:"on"`
+		Password PIN  `json:"password,omitempty"`
+	}{on, pin}
+	return c.PostCommand(ctx, ids, "set_valet_mode", &params)
+}
+
+// ResetValetPin clears the valet mode PIN.  Valet mode must be off.
+func (c *Client) ResetValetPin(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "reset_valet_pin", nil)
+}
+
+// SpeedLimitActivate turns on speed limit mode, using the given PIN.
+func (c *Client) SpeedLimitActivate(ctx context.Context, ids string, pin PIN) (*CommandResult, error) {
+	if err := pin.Validate(); err != nil {
+		return nil, err
+	}
+	return c.PostCommand(ctx, ids, "speed_limit_activate", &pinParams{pin})
+}
+
+// SpeedLimitDeactivate turns off speed limit mode.  The PIN must match
+// the one used to activate it.
+func (c *Client) SpeedLimitDeactivate(ctx context.Context, ids string, pin PIN) (*CommandResult, error) {
+	if err := pin.Validate(); err != nil {
+		return nil, err
+	}
+	return c.PostCommand(ctx, ids, "speed_limit_deactivate", &pinParams{pin})
+}
+
+// SpeedLimitClearPin clears the speed limit mode PIN.
+func (c *Client) SpeedLimitClearPin(ctx context.Context, ids string, pin PIN) (*CommandResult, error) {
+	if err := pin.Validate(); err != nil {
+		return nil, err
+	}
+	return c.PostCommand(ctx, ids, "speed_limit_clear_pin", &pinParams{pin})
+}
+
+// SpeedLimitSetLimit sets the maximum speed (in MPH) for speed limit
+// mode.  The current VehicleState is retrieved first in order to check
+// the limit against the range the vehicle supports.
+func (c *Client) SpeedLimitSetLimit(ctx context.Context, ids string, mph float64) (*CommandResult, error) {
+	vs, err := c.GetVehicleState(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if err = vs.SpeedLimitMode.CheckLimit(mph); err != nil {
+		return nil, err
+	}
+	params := struct {
+		LimitMph float64 `json:"limit_mph"`
+	}{mph}
+	return c.PostCommand(ctx, ids, "speed_limit_set_limit", &params)
+}
+
+// RemoteStartDrive enables keyless driving for a couple of minutes.
+func (c *Client) RemoteStartDrive(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "remote_start_drive", nil)
+}
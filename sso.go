@@ -0,0 +1,443 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//
+// Tesla SSO (single sign-on) authentication
+//
+// Logging in is a multi-step process:  an OAuth2 authorization code
+// flow with PKCE against the SSO server (possibly including an MFA
+// passcode), an exchange of the authorization code for an SSO token,
+// and finally an exchange of the SSO token for an owner API token.
+//
+
+// SSOBaseURL is the leading part of the Tesla SSO URL.
+var SSOBaseURL = "https://auth.tesla.com"
+
+// SSO OAuth2 parameters
+const ssoClientID = "ownerapi"
+const ssoRedirectURI = "https://auth.tesla.com/void/callback"
+const ssoScope = "openid email offline_access"
+
+// ErrMFARequired is returned when logging in to an account that has
+// multi-factor authentication enabled, without a way to get a passcode.
+var ErrMFARequired = errors.New("MFA passcode required")
+
+// A PasscodeFunc supplies a multi-factor authentication passcode
+// (for example, by prompting the user).  It is only called if the
+// account has MFA enabled.
+type PasscodeFunc func(ctx context.Context) (string, error)
+
+// SSOToken is the token returned by the SSO server.  Its access token
+// is exchanged for an owner API Token; its refresh token is kept in
+// Token.SSORefreshToken.
+type SSOToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+}
+
+// WithSSOBaseURL sets the leading part of the SSO URL.  The default
+// is the value of SSOBaseURL at the time the Client is created.
+func WithSSOBaseURL(url string) ClientOption {
+	return func(c *Client) {
+		c.ssoBaseURL = url
+	}
+}
+
+// WithPasscodeFunc sets the function used by GetToken to get an MFA
+// passcode.  If none is set, logging in to an account with MFA enabled
+// fails with ErrMFARequired.
+func WithPasscodeFunc(f PasscodeFunc) ClientOption {
+	return func(c *Client) {
+		c.passcode = f
+	}
+}
+
+// randomString returns a random URL-safe string, encoding n random bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newPKCE returns a PKCE code verifier and its (S256) code challenge.
+func newPKCE() (verifier string, challenge string, err error) {
+	verifier, err = randomString(64)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+	return verifier, challenge, nil
+}
+
+// hiddenInputRE matches hidden form inputs on the SSO login page.
+var hiddenInputRE = regexp.MustCompile(`<input[^>]*type="hidden"[^>]*>`)
+var inputNameRE = regexp.MustCompile(`name="([^"]*)"`)
+var inputValueRE = regexp.MustCompile(`value="([^"]*)"`)
+
+// parseHiddenInputs returns the hidden inputs of the login form.
+func parseHiddenInputs(page []byte) url.Values {
+	form := url.Values{}
+	for _, input := range hiddenInputRE.FindAll(page, -1) {
+		name := inputNameRE.FindSubmatch(input)
+		if name == nil {
+			continue
+		}
+		var value string
+		if v := inputValueRE.FindSubmatch(input); v != nil {
+			value = html.UnescapeString(string(v[1]))
+		}
+		form.Set(html.UnescapeString(string(name[1])), value)
+	}
+	return form
+}
+
+// ssoSession holds the state of one SSO login.  The SSO server needs
+// cookies to be preserved between requests, and redirects must not be
+// followed, since the final redirect (which carries the authorization
+// code) goes to a URL that doesn't exist.
+type ssoSession struct {
+	c       *Client
+	hc      *http.Client
+	authURL string
+}
+
+// newSSOSession starts an SSO login, using the Client's HTTP transport.
+func (c *Client) newSSOSession(query url.Values) (*ssoSession, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	base := c.httpClient
+	if base == nil {
+		base = http.DefaultClient
+	}
+	hc := &http.Client{
+		Transport: base.Transport,
+		Jar:       jar,
+		Timeout:   base.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &ssoSession{
+		c:       c,
+		hc:      hc,
+		authURL: c.ssoBaseURL + "/oauth2/v3/authorize?" + query.Encode(),
+	}, nil
+}
+
+// do makes one request to the SSO server, returning the response
+// (whose body has been closed) and its body.
+func (s *ssoSession) do(ctx context.Context, method string, url string, contentType string, body io.Reader) (*http.Response, []byte, error) {
+	var verbose = false
+
+	if verbose {
+		fmt.Printf("SSO URL: %s %s\n", method, url)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Add("User-Agent", s.c.userAgent)
+	req.Header.Add("Accept", "*/*")
+	if contentType != "" {
+		req.Header.Add("Content-Type", contentType)
+	}
+
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if verbose {
+		fmt.Printf("SSO Status: %s\n", resp.Status)
+	}
+	return resp, respBody, nil
+}
+
+// doJSON POSTs a JSON object to the SSO server, or GETs if in is nil,
+// and unmarshals the JSON response into out.
+func (s *ssoSession) doJSON(ctx context.Context, endpoint string, in interface{}, out interface{}) error {
+	method := "GET"
+	var body io.Reader
+	if in != nil {
+		method = "POST"
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	resp, respBody, err := s.do(ctx, method, s.c.ssoBaseURL+endpoint, "application/json", body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp, method, endpoint, respBody)
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// authorizationCode extracts the authorization code from the redirect
+// that ends a successful login.  Returns an empty string if resp isn't
+// that redirect.
+func authorizationCode(resp *http.Response, state string) (string, error) {
+	switch resp.StatusCode {
+	case http.StatusFound, http.StatusSeeOther:
+		/* break */
+	default:
+		return "", nil
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", err
+	}
+	q := loc.Query()
+	if q.Get("state") != state {
+		return "", errors.New("SSO state mismatch")
+	}
+	if e := q.Get("error"); e != "" {
+		return "", fmt.Errorf("SSO authorization failed: %s", e)
+	}
+	return q.Get("code"), nil
+}
+
+// mfa does the multi-factor authentication step of a login, and
+// returns the authorization code.
+func (s *ssoSession) mfa(ctx context.Context, transactionID string, state string, passcode PasscodeFunc) (string, error) {
+	var factors struct {
+		Data []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"data"`
+	}
+	err := s.doJSON(ctx, "/oauth2/v3/authorize/mfa/factors?transaction_id="+url.QueryEscape(transactionID), nil, &factors)
+	if err != nil {
+		return "", err
+	}
+	if len(factors.Data) == 0 {
+		return "", errors.New("no MFA factors registered")
+	}
+
+	code, err := passcode(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// We don't know which device generated the passcode, so try
+	// all of them.
+	verified := false
+	for _, f := range factors.Data {
+		req := struct {
+			TransactionID string `json:"transaction_id"`
+			FactorID      string `json:"factor_id"`
+			Passcode      string `json:"passcode"`
+		}{transactionID, f.ID, code}
+		var resp struct {
+			Data struct {
+				Approved bool `json:"approved"`
+				Valid    bool `json:"valid"`
+			} `json:"data"`
+		}
+		err = s.doJSON(ctx, "/oauth2/v3/authorize/mfa/verify", &req, &resp)
+		if err != nil {
+			return "", err
+		}
+		if resp.Data.Approved && resp.Data.Valid {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", errors.New("MFA passcode not valid")
+	}
+
+	// Resubmit the form, now that the transaction has been verified
+	form := url.Values{}
+	form.Set("transaction_id", transactionID)
+	resp, _, err := s.do(ctx, "POST", s.authURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	authCode, err := authorizationCode(resp, state)
+	if err != nil {
+		return "", err
+	}
+	if authCode == "" {
+		return "", fmt.Errorf("SSO login failed after MFA: %s", resp.Status)
+	}
+	return authCode, nil
+}
+
+// ssoLogin logs in to the Tesla SSO server with an email address and
+// password, and returns an owner API Token.  If the account has MFA
+// enabled, c.passcode is called to get a passcode.
+func (c *Client) ssoLogin(ctx context.Context, username string, password string) (*Token, error) {
+	verifier, challenge, err := newPKCE()
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("client_id", ssoClientID)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+	query.Set("redirect_uri", ssoRedirectURI)
+	query.Set("response_type", "code")
+	query.Set("scope", ssoScope)
+	query.Set("state", state)
+	query.Set("login_hint", username)
+	s, err := c.newSSOSession(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the login form
+	resp, page, err := s.do(ctx, "GET", s.authURL, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp, "GET", "/oauth2/v3/authorize", page)
+	}
+
+	// Fill it in and submit it
+	form := parseHiddenInputs(page)
+	form.Set("identity", username)
+	form.Set("credential", password)
+	resp, page, err = s.do(ctx, "POST", s.authURL, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	code, err := authorizationCode(resp, state)
+	if err != nil {
+		return nil, err
+	}
+	if code == "" {
+		// No redirect, so either we need MFA or the login failed.
+		if resp.StatusCode != http.StatusOK || !bytes.Contains(page, []byte("/mfa/verify")) {
+			return nil, newAPIError(resp, "POST", "/oauth2/v3/authorize", page)
+		}
+		if c.passcode == nil {
+			return nil, ErrMFARequired
+		}
+		code, err = s.mfa(ctx, form.Get("transaction_id"), state, c.passcode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Exchange the authorization code for an SSO token
+	req := map[string]string{
+		"grant_type":    "authorization_code",
+		"client_id":     ssoClientID,
+		"code":          code,
+		"code_verifier": verifier,
+		"redirect_uri":  ssoRedirectURI,
+	}
+	var st SSOToken
+	err = s.doJSON(ctx, "/oauth2/v3/token", req, &st)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.ownerToken(ctx, &st)
+}
+
+// refreshTokenSSO refreshes a Token using its SSO refresh token.
+func (c *Client) refreshTokenSSO(ctx context.Context, token *Token) (*Token, error) {
+	s, err := c.newSSOSession(url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	req := map[string]string{
+		"grant_type":    "refresh_token",
+		"client_id":     ssoClientID,
+		"refresh_token": token.SSORefreshToken,
+		"scope":         ssoScope,
+	}
+	var st SSOToken
+	err = s.doJSON(ctx, "/oauth2/v3/token", req, &st)
+	if err != nil {
+		return nil, err
+	}
+	if st.RefreshToken == "" {
+		st.RefreshToken = token.SSORefreshToken
+	}
+
+	return c.ownerToken(ctx, &st)
+}
+
+// ownerToken exchanges an SSO token for an owner API Token.
+func (c *Client) ownerToken(ctx context.Context, st *SSOToken) (*Token, error) {
+	var t Token
+
+	auth := Auth{
+		GrantType:    "urn:ietf:params:oauth:grant-type:jwt-bearer",
+		ClientID:     teslaClientID,
+		ClientSecret: teslaClientSecret,
+	}
+	authjson, err := json.Marshal(&auth)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.ownerAPI().doTesla(ctx, "POST", "/oauth/token", authjson, &Token{AccessToken: st.AccessToken})
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &t)
+	if err != nil {
+		return nil, err
+	}
+	if t.CreatedAt == 0 {
+		t.CreatedAt = int(time.Now().Unix())
+	}
+	t.SSORefreshToken = st.RefreshToken
+
+	return &t, nil
+}
@@ -0,0 +1,301 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// fakeSSO is a fake Tesla SSO server (and owner API token endpoint),
+// for one account.
+type fakeSSO struct {
+	*httptest.Server
+
+	username string
+	password string
+	passcode string // MFA passcode, if MFA is enabled
+
+	mu         sync.Mutex
+	challenge  string // PKCE code challenge for the current login
+	state      string
+	verified   bool // MFA verified for the current login
+	codes      map[string]bool
+	refreshes  int
+	exchanges  int
+	lastBearer string
+}
+
+const fakeTransactionID = "txn-1"
+
+func newFakeSSO(passcode string) *fakeSSO {
+	f := &fakeSSO{
+		username: "elon@example.com",
+		password: "hunter2",
+		passcode: passcode,
+		codes:    make(map[string]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v3/authorize", f.authorize)
+	mux.HandleFunc("/oauth2/v3/authorize/mfa/factors", f.factors)
+	mux.HandleFunc("/oauth2/v3/authorize/mfa/verify", f.verify)
+	mux.HandleFunc("/oauth2/v3/token", f.token)
+	mux.HandleFunc("/oauth/token", f.ownerToken)
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeSSO) client(opts ...ClientOption) *Client {
+	opts = append([]ClientOption{WithBaseURL(f.URL), WithSSOBaseURL(f.URL), WithRetryPolicy(NoRetryPolicy)}, opts...)
+	return NewClient(opts...)
+}
+
+// redirect ends a successful login, with a new authorization code.
+func (f *fakeSSO) redirect(w http.ResponseWriter) {
+	code := fmt.Sprintf("code-%d", len(f.codes)+1)
+	f.codes[code] = true
+	loc := ssoRedirectURI + "?code=" + code + "&state=" + url.QueryEscape(f.state)
+	w.Header().Set("Location", loc)
+	w.WriteHeader(http.StatusFound)
+}
+
+func (f *fakeSSO) authorize(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	if r.Method == "GET" {
+		if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" ||
+			q.Get("client_id") != ssoClientID || q.Get("login_hint") != f.username {
+			http.Error(w, "bad authorize request", http.StatusBadRequest)
+			return
+		}
+		f.challenge = q.Get("code_challenge")
+		f.state = q.Get("state")
+		f.verified = false
+		http.SetCookie(w, &http.Cookie{Name: "tesla-auth.sid", Value: "session"})
+		fmt.Fprintf(w, `<form method="post">
+<input type="hidden" name="_csrf" value="csrf&amp;token">
+<input type="hidden" name="transaction_id" value="%s">
+<input type="text" name="identity">
+</form>`, fakeTransactionID)
+		return
+	}
+
+	if _, err := r.Cookie("tesla-auth.sid"); err != nil {
+		http.Error(w, "no session", http.StatusForbidden)
+		return
+	}
+	r.ParseForm()
+	if r.PostForm.Get("transaction_id") != fakeTransactionID {
+		http.Error(w, "bad transaction", http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("identity") == "" {
+		// Resubmitted after MFA
+		if !f.verified {
+			http.Error(w, "not verified", http.StatusForbidden)
+			return
+		}
+		f.redirect(w)
+		return
+	}
+	if r.PostForm.Get("_csrf") != "csrf&token" {
+		http.Error(w, "bad csrf", http.StatusForbidden)
+		return
+	}
+	if r.PostForm.Get("identity") != f.username || r.PostForm.Get("credential") != f.password {
+		http.Error(w, "bad credentials", http.StatusUnauthorized)
+		return
+	}
+	if f.passcode != "" {
+		w.Write([]byte(`<html><script>fetch("/oauth2/v3/authorize/mfa/verify")</script></html>`))
+		return
+	}
+	f.redirect(w)
+}
+
+func (f *fakeSSO) factors(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("transaction_id") != fakeTransactionID {
+		http.Error(w, "bad transaction", http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(`{"data":[{"id":"factor-1","name":"Phone"},{"id":"factor-2","name":"Tablet"}]}`))
+}
+
+func (f *fakeSSO) verify(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TransactionID string `json:"transaction_id"`
+		FactorID      string `json:"factor_id"`
+		Passcode      string `json:"passcode"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Only the second device has the right passcode
+	ok := req.TransactionID == fakeTransactionID && req.FactorID == "factor-2" && req.Passcode == f.passcode
+	if ok {
+		f.verified = true
+	}
+	fmt.Fprintf(w, `{"data":{"approved":%v,"valid":%v}}`, ok, ok)
+}
+
+func (f *fakeSSO) token(w http.ResponseWriter, r *http.Request) {
+	var req map[string]string
+	json.NewDecoder(r.Body).Decode(&req)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch req["grant_type"] {
+	case "authorization_code":
+		sum := sha256.Sum256([]byte(req["code_verifier"]))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenge {
+			http.Error(w, `{"error":"invalid_grant","error_description":"PKCE"}`, http.StatusBadRequest)
+			return
+		}
+		if !f.codes[req["code"]] || req["redirect_uri"] != ssoRedirectURI {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(f.codes, req["code"])
+		w.Write([]byte(`{"access_token":"sso-access-1","refresh_token":"sso-refresh","expires_in":300}`))
+	case "refresh_token":
+		if req["refresh_token"] != "sso-refresh" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		f.refreshes++
+		// No new refresh token; the old one stays valid
+		fmt.Fprintf(w, `{"access_token":"sso-access-%d","expires_in":300}`, f.refreshes+1)
+	default:
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+	}
+}
+
+func (f *fakeSSO) ownerToken(w http.ResponseWriter, r *http.Request) {
+	var auth Auth
+	json.NewDecoder(r.Body).Decode(&auth)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if auth.GrantType != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+	f.exchanges++
+	f.lastBearer = r.Header.Get("Authorization")
+	fmt.Fprintf(w, `{"access_token":"owner-%d","token_type":"bearer","expires_in":3888000,"refresh_token":"owner-refresh","created_at":1600000000}`, f.exchanges)
+}
+
+func TestSSOLogin(t *testing.T) {
+	f := newFakeSSO("")
+	defer f.Close()
+
+	tok, err := f.client().GetToken(context.Background(), &f.username, &f.password)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	if tok.AccessToken != "owner-1" || tok.SSORefreshToken != "sso-refresh" {
+		t.Errorf("got token %+v", tok)
+	}
+	if f.lastBearer != "Bearer sso-access-1" {
+		t.Errorf("owner token exchange authenticated with %q", f.lastBearer)
+	}
+}
+
+func TestSSOLoginBadPassword(t *testing.T) {
+	f := newFakeSSO("")
+	defer f.Close()
+
+	password := "wrong"
+	_, err := f.client().GetToken(context.Background(), &f.username, &password)
+	if statusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("got error %v, want 401", err)
+	}
+}
+
+func TestSSOLoginMFA(t *testing.T) {
+	f := newFakeSSO("123456")
+	defer f.Close()
+
+	// Without a PasscodeFunc
+	_, err := f.client().GetToken(context.Background(), &f.username, &f.password)
+	if !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("got error %v, want ErrMFARequired", err)
+	}
+
+	// With the wrong passcode
+	wrong := func(ctx context.Context) (string, error) { return "000000", nil }
+	_, err = f.client(WithPasscodeFunc(wrong)).GetToken(context.Background(), &f.username, &f.password)
+	if err == nil {
+		t.Fatal("GetToken succeeded with wrong passcode")
+	}
+
+	// With the right one
+	right := func(ctx context.Context) (string, error) { return "123456", nil }
+	tok, err := f.client(WithPasscodeFunc(right)).GetToken(context.Background(), &f.username, &f.password)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+	if tok.AccessToken != "owner-1" || tok.SSORefreshToken != "sso-refresh" {
+		t.Errorf("got token %+v", tok)
+	}
+}
+
+func TestSSORefresh(t *testing.T) {
+	f := newFakeSSO("")
+	defer f.Close()
+
+	old := &Token{AccessToken: "owner-0", RefreshToken: "owner-refresh", SSORefreshToken: "sso-refresh"}
+	tok, err := f.client().RefreshToken(context.Background(), old)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	if f.refreshes != 1 {
+		t.Errorf("got %d SSO refreshes, want 1", f.refreshes)
+	}
+	if f.lastBearer != "Bearer sso-access-2" {
+		t.Errorf("owner token exchange authenticated with %q", f.lastBearer)
+	}
+	if tok.AccessToken != "owner-1" || tok.SSORefreshToken != "sso-refresh" {
+		t.Errorf("got token %+v", tok)
+	}
+}
+
+func TestSSONilHTTPClient(t *testing.T) {
+	f := newFakeSSO("")
+	defer f.Close()
+
+	c := f.client(WithHTTPClient(nil))
+	_, err := c.GetToken(context.Background(), &f.username, &f.password)
+	if err != nil {
+		t.Fatalf("GetToken: %v", err)
+	}
+}
+
+func TestParseHiddenInputs(t *testing.T) {
+	page := []byte(`<input type="hidden" name="a" value="1&amp;2"><input type="text" name="b" value="x"><input name="c" type="hidden">`)
+	form := parseHiddenInputs(page)
+	if form.Get("a") != "1&2" || form.Get("b") != "" {
+		t.Errorf("got %v", form)
+	}
+	if _, ok := form["c"]; !ok {
+		t.Errorf("hidden input without value missing: %v", form)
+	}
+}
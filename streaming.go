@@ -0,0 +1,389 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//
+// Streaming API
+//
+// The streaming websocket pushes a vehicle's speed, location, power
+// and so on a few times per second while it is awake.  A client sends
+// a subscribe message naming the vehicle (by vehicle_id) and the
+// columns it wants; the server then sends data:update messages whose
+// value is a comma-separated list of a timestamp and those columns.
+// When the vehicle goes to sleep or the connection times out, the
+// server sends data:error, and the client must subscribe again.
+//
+
+// StreamingURL is the URL of the streaming websocket.  A Client uses
+// the value at the time it is created; see WithStreamingURL.
+var StreamingURL = "wss://streaming.vn.teslamotors.com/streaming/"
+
+// streamColumns are the columns we subscribe to, in the order they
+// appear in data:update values (after the timestamp).
+var streamColumns = []string{
+	"speed", "odometer", "soc", "elevation", "est_heading", "est_lat",
+	"est_lng", "power", "shift_state", "range", "est_range", "heading",
+}
+
+// Delays before subscribing again after an error.  The delay starts at
+// streamBackoffInitial and doubles up to streamBackoffMax, and goes
+// back to the start once a sample arrives.
+var streamBackoffInitial = 1 * time.Second
+var streamBackoffMax = 2 * time.Minute
+
+// streamReadTimeout is how long to wait for a message before giving
+// up on a connection and reconnecting.
+var streamReadTimeout = 60 * time.Second
+
+// WithStreamingURL sets the URL of the streaming websocket.  The
+// default is the value of StreamingURL at the time the Client is
+// created.
+func WithStreamingURL(url string) ClientOption {
+	return func(c *Client) {
+		c.streamingURL = url
+	}
+}
+
+// ErrStreamingFleet is the error from a Stream started in Fleet API
+// mode, which has no streaming API.
+var ErrStreamingFleet = errors.New("streaming API not available in Fleet API mode")
+
+// A StreamSample is one data:update message from the streaming API.
+// Columns that the vehicle didn't report (for example, speed while
+// parked) are zero.
+type StreamSample struct {
+	Time       time.Time
+	Speed      int     // mph
+	Odometer   float64 // miles
+	Soc        int     // state of charge, percent
+	Elevation  int
+	EstHeading int
+	EstLat     float64
+	EstLng     float64
+	Power      int    // kW, negative while charging or regenerating
+	ShiftState string // "P", "D", "R", "N", or empty
+	Range      int    // rated range, miles
+	EstRange   int    // estimated range, miles
+	Heading    int
+}
+
+// A StreamError is a data:error message from the streaming API.
+type StreamError struct {
+	Type  string // "vehicle_disconnected", "vehicle_error", "client_error"
+	Value string
+}
+
+// Error returns a string representation of a StreamError.
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("streaming: %s: %s", e.Type, strings.TrimSpace(e.Value))
+}
+
+// IsVehicleDisconnected returns true if err indicates that the
+// streaming API lost contact with the vehicle (usually because it has
+// gone to sleep).
+func IsVehicleDisconnected(err error) bool {
+	var e *StreamError
+	return errors.As(err, &e) && e.Type == "vehicle_disconnected"
+}
+
+// isStreamAuthError returns true if err indicates that the streaming
+// API rejected our credentials.
+func isStreamAuthError(err error) bool {
+	var e *StreamError
+	return errors.As(err, &e) && e.Type == "client_error"
+}
+
+// A Stream delivers samples from a vehicle.  C is closed when the
+// stream stops, after which Err reports why.
+type Stream struct {
+	C <-chan StreamSample
+
+	done chan struct{}
+	err  error
+}
+
+// Err returns the error that stopped a Stream:  the context's error if
+// it was canceled, a *StreamError if the streaming API wouldn't accept
+// our credentials, the error from getting new ones (refreshing the
+// access token, or fetching fresh vehicle tokens), or
+// ErrStreamingFleet in Fleet API mode.  It returns nil while the
+// Stream is running.
+func (s *Stream) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// StreamVehicle subscribes to the streaming API for a vehicle,
+// authenticating with the Client's access token.  Samples are
+// delivered on the returned Stream until ctx is canceled.  Errors,
+// including the vehicle going to sleep (vehicle_disconnected), are
+// handled by subscribing again, with backoff.  If the access token is
+// rejected and the Client's TokenSource can refresh it, it is
+// refreshed once.
+//
+// The streaming API is part of the owner API; it isn't available in
+// Fleet API mode (see the telemetry package instead), where the
+// Stream stops at once with ErrStreamingFleet.
+func (c *Client) StreamVehicle(ctx context.Context, v *Vehicle) *Stream {
+	return c.startStream(ctx, v, "")
+}
+
+// StreamVehicleLegacy is like StreamVehicle, but authenticates with
+// the account's email address and the vehicle's streaming tokens
+// (Vehicle.Tokens), as older API stacks require.  The tokens change
+// from time to time; if they are rejected, fresh ones are fetched
+// from the vehicles list.
+func (c *Client) StreamVehicleLegacy(ctx context.Context, v *Vehicle, email string) *Stream {
+	return c.startStream(ctx, v, email)
+}
+
+// startStream is the common code for StreamVehicle and
+// StreamVehicleLegacy.
+func (c *Client) startStream(ctx context.Context, v *Vehicle, email string) *Stream {
+	ch := make(chan StreamSample, 16)
+	s := &Stream{C: ch, done: make(chan struct{})}
+	if c.fleet {
+		s.err = ErrStreamingFleet
+		close(s.done)
+		close(ch)
+		return s
+	}
+	go func() {
+		s.err = c.stream(ctx, *v, email, ch)
+		close(s.done)
+		close(ch)
+	}()
+	return s
+}
+
+// stream subscribes to a vehicle's stream until ctx is canceled or
+// our credentials are rejected for good.
+func (c *Client) stream(ctx context.Context, v Vehicle, email string, ch chan<- StreamSample) error {
+	var verbose = false
+
+	backoff := streamBackoffInitial
+	reauthed := false
+	for {
+		received, err := c.streamOnce(ctx, &v, email, ch)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if verbose {
+			fmt.Printf("Stream %s: %v\n", v.IDS, err)
+		}
+		if received {
+			backoff = streamBackoffInitial
+			reauthed = false
+		}
+
+		if isStreamAuthError(err) {
+			if reauthed {
+				return err
+			}
+			reauthed = true
+			err = c.streamReauth(ctx, &v, email, err)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = sleepContext(ctx, backoff)
+		if err != nil {
+			return err
+		}
+		backoff *= 2
+		if backoff > streamBackoffMax {
+			backoff = streamBackoffMax
+		}
+	}
+}
+
+// streamReauth gets new credentials after the streaming API rejected
+// ours (with cause):  a refreshed access token, or fresh vehicle
+// tokens.
+func (c *Client) streamReauth(ctx context.Context, v *Vehicle, email string, cause error) error {
+	if email != "" {
+		fresh, err := c.findVehicle(ctx, v.IDS)
+		if err != nil {
+			return err
+		}
+		*v = *fresh
+		return nil
+	}
+
+	token, err := c.token(ctx)
+	if err != nil {
+		return err
+	}
+	r, ok := c.tokenSource.(tokenRefresher)
+	if token == nil || !ok {
+		return cause
+	}
+	token, err = r.Refresh(ctx, token)
+	if token == nil {
+		return err
+	}
+	return nil
+}
+
+// streamMessage is a message to or from the streaming API.
+type streamMessage struct {
+	MsgType           string `json:"msg_type"`
+	Tag               string `json:"tag,omitempty"`
+	Token             string `json:"token,omitempty"`
+	Value             string `json:"value,omitempty"`
+	ErrorType         string `json:"error_type,omitempty"`
+	ConnectionTimeout int    `json:"connection_timeout,omitempty"` // ms
+}
+
+// streamOnce makes one streaming connection, and reads samples from
+// it until there is an error.  received reports whether any samples
+// arrived.
+func (c *Client) streamOnce(ctx context.Context, v *Vehicle, email string, ch chan<- StreamSample) (received bool, err error) {
+	tag := strconv.Itoa(v.VehicleID)
+	sub := streamMessage{
+		MsgType: "data:subscribe_oauth",
+		Tag:     tag,
+		Value:   strings.Join(streamColumns, ","),
+	}
+	if email != "" {
+		if len(v.Tokens) == 0 {
+			return false, &StreamError{Type: "client_error", Value: "no vehicle streaming tokens"}
+		}
+		sub.MsgType = "data:subscribe"
+		sub.Token = base64.StdEncoding.EncodeToString([]byte(email + ":" + v.Tokens[0]))
+	} else {
+		token, err := c.token(ctx)
+		if err != nil {
+			return false, err
+		}
+		if token != nil {
+			sub.Token = token.AccessToken
+		}
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 30 * time.Second,
+	}
+	if tr, ok := c.httpClient.Transport.(*http.Transport); ok {
+		dialer.Proxy = tr.Proxy
+		dialer.TLSClientConfig = tr.TLSClientConfig
+	}
+	header := http.Header{}
+	header.Set("User-Agent", c.userAgent)
+	conn, _, err := dialer.DialContext(ctx, c.streamingURL, header)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Close the connection if ctx is canceled, to interrupt reads
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	err = conn.WriteJSON(&sub)
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		var msg streamMessage
+		err = conn.ReadJSON(&msg)
+		if err != nil {
+			return received, err
+		}
+
+		switch msg.MsgType {
+		case "data:update":
+			if msg.Tag != tag {
+				continue
+			}
+			sample, err := parseStreamSample(msg.Value)
+			if err != nil {
+				continue
+			}
+			select {
+			case ch <- *sample:
+				received = true
+			case <-ctx.Done():
+				return received, ctx.Err()
+			}
+		case "data:error":
+			return received, &StreamError{Type: msg.ErrorType, Value: msg.Value}
+		}
+	}
+}
+
+// parseStreamSample decodes the value of a data:update message.
+func parseStreamSample(value string) (*StreamSample, error) {
+	cols := strings.Split(value, ",")
+	if len(cols) != len(streamColumns)+1 {
+		return nil, fmt.Errorf("streaming: %d columns, expected %d", len(cols), len(streamColumns)+1)
+	}
+	ms, err := strconv.ParseInt(cols[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	atoi := func(s string) int {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			// Some columns are occasionally reported with a fraction
+			f, _ := strconv.ParseFloat(s, 64)
+			n = int(f)
+		}
+		return n
+	}
+	atof := func(s string) float64 {
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	}
+	return &StreamSample{
+		Time:       time.Unix(0, ms*int64(time.Millisecond)),
+		Speed:      atoi(cols[1]),
+		Odometer:   atof(cols[2]),
+		Soc:        atoi(cols[3]),
+		Elevation:  atoi(cols[4]),
+		EstHeading: atoi(cols[5]),
+		EstLat:     atof(cols[6]),
+		EstLng:     atof(cols[7]),
+		Power:      atoi(cols[8]),
+		ShiftState: cols[9],
+		Range:      atoi(cols[10]),
+		EstRange:   atoi(cols[11]),
+		Heading:    atoi(cols[12]),
+	}, nil
+}
@@ -0,0 +1,284 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A streamScript handles the nth connection to a fakeStreaming
+// server, after the client has subscribed with sub.
+type streamScript func(n int, sub streamMessage, conn *websocket.Conn)
+
+// fakeStreaming is a fake streaming API websocket server.
+type fakeStreaming struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	subs []streamMessage
+}
+
+func newFakeStreaming(script streamScript) *fakeStreaming {
+	f := &fakeStreaming{}
+	var upgrader websocket.Upgrader
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var sub streamMessage
+		if err := conn.ReadJSON(&sub); err != nil {
+			return
+		}
+		f.mu.Lock()
+		n := len(f.subs)
+		f.subs = append(f.subs, sub)
+		f.mu.Unlock()
+		script(n, sub, conn)
+	}))
+	return f
+}
+
+func (f *fakeStreaming) client(opts ...ClientOption) *Client {
+	url := "ws" + strings.TrimPrefix(f.URL, "http")
+	return NewClient(append([]ClientOption{WithStreamingURL(url)}, opts...)...)
+}
+
+func (f *fakeStreaming) subscriptions() []streamMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]streamMessage{}, f.subs...)
+}
+
+const testStreamValue = "1600000000000,55,12345.6,80,100,90,37.5,-122.25,20,D,250,230,91"
+
+// sendUpdate sends a data:update message.
+func sendUpdate(conn *websocket.Conn, tag string, value string) {
+	conn.WriteJSON(&streamMessage{MsgType: "data:update", Tag: tag, Value: value})
+}
+
+// sendError sends a data:error message.
+func sendError(conn *websocket.Conn, errorType string) {
+	conn.WriteJSON(&streamMessage{MsgType: "data:error", Tag: "42", ErrorType: errorType, Value: errorType})
+}
+
+// hold keeps a connection open until the client closes it.
+func hold(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// fastStreamBackoff shortens the reconnection delays, returning a
+// function that restores them.
+func fastStreamBackoff() func() {
+	initial, max := streamBackoffInitial, streamBackoffMax
+	streamBackoffInitial, streamBackoffMax = time.Millisecond, 10*time.Millisecond
+	return func() {
+		streamBackoffInitial, streamBackoffMax = initial, max
+	}
+}
+
+// nextSample returns the next sample from a Stream, or fails if there
+// isn't one.
+func nextSample(t *testing.T, s *Stream) StreamSample {
+	t.Helper()
+	select {
+	case sample, ok := <-s.C:
+		if !ok {
+			t.Fatalf("stream stopped: %v", s.Err())
+		}
+		return sample
+	case <-time.After(5 * time.Second):
+		t.Fatal("no sample")
+	}
+	return StreamSample{}
+}
+
+// waitStopped waits for a Stream to stop, returning its error.
+func waitStopped(t *testing.T, s *Stream) error {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-s.C:
+			if !ok {
+				return s.Err()
+			}
+		case <-timeout:
+			t.Fatal("stream didn't stop")
+		}
+	}
+}
+
+var testStreamVehicle = &Vehicle{VehicleID: 42, IDS: "1"}
+
+func TestStreamSamples(t *testing.T) {
+	f := newFakeStreaming(func(n int, sub streamMessage, conn *websocket.Conn) {
+		sendUpdate(conn, "7", testStreamValue) // another vehicle
+		sendUpdate(conn, "42", "garbage")
+		sendUpdate(conn, "42", testStreamValue)
+		hold(conn)
+	})
+	defer f.Close()
+	c := f.client(WithToken(&Token{AccessToken: "access"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := c.StreamVehicle(ctx, testStreamVehicle)
+	got := nextSample(t, s)
+	want := StreamSample{
+		Time:       time.Unix(1600000000, 0),
+		Speed:      55,
+		Odometer:   12345.6,
+		Soc:        80,
+		Elevation:  100,
+		EstHeading: 90,
+		EstLat:     37.5,
+		EstLng:     -122.25,
+		Power:      20,
+		ShiftState: "D",
+		Range:      250,
+		EstRange:   230,
+		Heading:    91,
+	}
+	if !got.Time.Equal(want.Time) {
+		t.Errorf("got time %v, want %v", got.Time, want.Time)
+	}
+	got.Time = want.Time
+	if got != want {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if s.Err() != nil {
+		t.Errorf("Err while running: %v", s.Err())
+	}
+
+	cancel()
+	if err := waitStopped(t, s); err != context.Canceled {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+
+	subs := f.subscriptions()
+	if len(subs) != 1 || subs[0].MsgType != "data:subscribe_oauth" || subs[0].Tag != "42" || subs[0].Token != "access" {
+		t.Errorf("got subscriptions %+v", subs)
+	}
+}
+
+func TestStreamReconnect(t *testing.T) {
+	defer fastStreamBackoff()()
+	f := newFakeStreaming(func(n int, sub streamMessage, conn *websocket.Conn) {
+		switch n {
+		case 0:
+			sendUpdate(conn, "42", testStreamValue)
+			sendError(conn, "vehicle_disconnected")
+		case 1:
+			sendError(conn, "vehicle_error")
+		default:
+			sendUpdate(conn, "42", testStreamValue)
+			hold(conn)
+		}
+	})
+	defer f.Close()
+	c := f.client(WithToken(&Token{AccessToken: "access"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := c.StreamVehicle(ctx, testStreamVehicle)
+	nextSample(t, s)
+	nextSample(t, s)
+	if n := len(f.subscriptions()); n != 3 {
+		t.Errorf("got %d subscriptions, want 3", n)
+	}
+	cancel()
+	waitStopped(t, s)
+}
+
+// streamRefresher is a TokenSource whose Refresh replaces the token
+// with one whose access token is next.
+type streamRefresher struct {
+	next string
+
+	mu        sync.Mutex
+	token     *Token
+	refreshes int
+}
+
+func (r *streamRefresher) Token(ctx context.Context) (*Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.token, nil
+}
+
+func (r *streamRefresher) Refresh(ctx context.Context, stale *Token) (*Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.token = &Token{AccessToken: r.next}
+	r.refreshes++
+	return r.token, nil
+}
+
+func TestStreamReauth(t *testing.T) {
+	defer fastStreamBackoff()()
+	f := newFakeStreaming(func(n int, sub streamMessage, conn *websocket.Conn) {
+		if sub.Token != "fresh" {
+			sendError(conn, "client_error")
+			return
+		}
+		sendUpdate(conn, "42", testStreamValue)
+		hold(conn)
+	})
+	defer f.Close()
+
+	// A rejected token is refreshed once
+	r := &streamRefresher{token: &Token{AccessToken: "stale"}, next: "fresh"}
+	c := f.client(WithTokenSource(r))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := c.StreamVehicle(ctx, testStreamVehicle)
+	nextSample(t, s)
+	if r.refreshes != 1 {
+		t.Errorf("got %d refreshes, want 1", r.refreshes)
+	}
+	cancel()
+	waitStopped(t, s)
+
+	// If the refreshed token is rejected too, the stream stops
+	r = &streamRefresher{token: &Token{AccessToken: "stale"}, next: "also stale"}
+	c = f.client(WithTokenSource(r))
+	s = c.StreamVehicle(context.Background(), testStreamVehicle)
+	err := waitStopped(t, s)
+	if !isStreamAuthError(err) {
+		t.Errorf("got error %v, want client_error", err)
+	}
+	if r.refreshes != 1 {
+		t.Errorf("got %d refreshes, want 1", r.refreshes)
+	}
+}
+
+func TestStreamFleet(t *testing.T) {
+	c := NewClient(WithFleetAPI(RegionNA), WithToken(&Token{AccessToken: "access"}))
+	s := c.StreamVehicle(context.Background(), testStreamVehicle)
+	if _, ok := <-s.C; ok {
+		t.Error("got a sample in Fleet API mode")
+	}
+	if err := s.Err(); !errors.Is(err, ErrStreamingFleet) {
+		t.Errorf("got error %v, want ErrStreamingFleet", err)
+	}
+}
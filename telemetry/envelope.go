@@ -0,0 +1,392 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package telemetry
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+//
+// Stream envelopes
+//
+// Vehicles don't send bare records.  Each websocket message is a
+// flatbuffers FlatbuffersEnvelope (as in Tesla's fleet-telemetry
+// server) holding a StreamMessage, whose topic says what its payload
+// is:  "V" for a vehicle_data Payload record, or "alerts", "errors"
+// and "connectivity" for other kinds of report.  The server
+// acknowledges each message it has handled by sending back an
+// envelope holding a StreamAckMessage with the same transaction ID;
+// the vehicle resends messages that aren't acknowledged.
+//
+// As with the protobufs, only the fields needed here are read and
+// written, directly.  The field IDs are:
+//
+//	table FlatbuffersEnvelope {
+//	  txid: [ubyte];          // 0
+//	  topic: [ubyte];         // 1
+//	  message_type: ubyte;    // 2 (union type)
+//	  message: Message;       // 3
+//	  messageId: [ubyte];     // 4
+//	}
+//	union Message { StreamMessage = 1, StreamAckMessage = 2 }
+//	table StreamMessage {
+//	  TXID: [ubyte];          // 0
+//	  senderID: [ubyte];      // 1
+//	  messageTopic: [ubyte];  // 2
+//	  payload: [ubyte];       // 3
+//	  deviceType: [ubyte];    // 4
+//	  deviceID: [ubyte];      // 5
+//	}
+//	table StreamAckMessage {
+//	  TXID: [ubyte];          // 0
+//	  messageTopic: [ubyte];  // 1
+//	  messageId: [ubyte];     // 2
+//	}
+//
+
+// TopicVehicleData is the topic of StreamMessages that carry records.
+const TopicVehicleData = "V"
+
+// Envelope message types
+const (
+	messageTypeStream = 1
+	messageTypeAck    = 2
+)
+
+// Envelope field IDs
+const (
+	envelopeTXID        = 0
+	envelopeTopic       = 1
+	envelopeMessageType = 2
+	envelopeMessage     = 3
+	envelopeMessageID   = 4
+
+	streamTXID       = 0
+	streamSenderID   = 1
+	streamTopic      = 2
+	streamPayload    = 3
+	streamDeviceType = 4
+	streamDeviceID   = 5
+
+	ackTXID      = 0
+	ackTopic     = 1
+	ackMessageID = 2
+)
+
+var errEnvelope = errors.New("malformed telemetry envelope")
+
+// A StreamMessage is a message from a vehicle, unwrapped from its
+// envelope.
+type StreamMessage struct {
+	TXID      []byte // transaction ID, echoed in the acknowledgement
+	Topic     string
+	MessageID []byte
+	DeviceID  string // the vehicle's VIN
+	Payload   []byte // a vehicle_data Payload, for TopicVehicleData
+}
+
+// ParseStreamMessage decodes an envelope holding a StreamMessage.
+func ParseStreamMessage(b []byte) (*StreamMessage, error) {
+	env, err := fbRoot(b)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := env.uint8(envelopeMessageType)
+	if err != nil {
+		return nil, err
+	}
+	if typ != messageTypeStream {
+		return nil, errEnvelope
+	}
+	sm, err := env.table(envelopeMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	var m StreamMessage
+	var topic, deviceID []byte
+	for _, f := range []struct {
+		t   fbTable
+		id  int
+		dst *[]byte
+	}{
+		{env, envelopeTXID, &m.TXID},
+		{env, envelopeTopic, &topic},
+		{env, envelopeMessageID, &m.MessageID},
+		{sm, streamTXID, &m.TXID},
+		{sm, streamTopic, &topic},
+		{sm, streamPayload, &m.Payload},
+		{sm, streamDeviceID, &deviceID},
+	} {
+		v, err := f.t.bytes(f.id)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			*f.dst = v
+		}
+	}
+	m.Topic = string(topic)
+	m.DeviceID = string(deviceID)
+	return &m, nil
+}
+
+// Marshal encodes a StreamMessage in an envelope, as a vehicle sends it.
+func (m *StreamMessage) Marshal() []byte {
+	sm := []fbField{
+		streamTXID:     fbVector(m.TXID),
+		streamTopic:    fbVector([]byte(m.Topic)),
+		streamPayload:  fbVector(m.Payload),
+		streamDeviceID: fbVector([]byte(m.DeviceID)),
+	}
+	return fbBuild([]fbField{
+		envelopeTXID:        fbVector(m.TXID),
+		envelopeTopic:       fbVector([]byte(m.Topic)),
+		envelopeMessageType: fbUint8(messageTypeStream),
+		envelopeMessage:     fbTableField(sm),
+		envelopeMessageID:   fbVector(m.MessageID),
+	})
+}
+
+// ack returns the envelope acknowledging a StreamMessage.
+func (m *StreamMessage) ack() []byte {
+	ack := []fbField{
+		ackTXID:      fbVector(m.TXID),
+		ackTopic:     fbVector([]byte(m.Topic)),
+		ackMessageID: fbVector(m.MessageID),
+	}
+	return fbBuild([]fbField{
+		envelopeTXID:        fbVector(m.TXID),
+		envelopeTopic:       fbVector([]byte(m.Topic)),
+		envelopeMessageType: fbUint8(messageTypeAck),
+		envelopeMessage:     fbTableField(ack),
+		envelopeMessageID:   fbVector(m.MessageID),
+	})
+}
+
+// parseStreamAck decodes an acknowledgement, returning its
+// transaction ID.
+func parseStreamAck(b []byte) ([]byte, error) {
+	env, err := fbRoot(b)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := env.uint8(envelopeMessageType)
+	if err != nil {
+		return nil, err
+	}
+	if typ != messageTypeAck {
+		return nil, errEnvelope
+	}
+	ack, err := env.table(envelopeMessage)
+	if err != nil {
+		return nil, err
+	}
+	return ack.bytes(ackTXID)
+}
+
+//
+// Flatbuffers
+//
+// A buffer starts with the offset of the root table.  A table starts
+// with the (signed, backwards) offset of its vtable, which lists the
+// offset of each field within the table, or 0 if it is absent.
+// Vectors and nested tables are referred to by (unsigned, forwards)
+// offsets from the field that refers to them.  All integers are
+// little-endian.
+//
+
+// An fbTable is a table in a flatbuffer being read.
+type fbTable struct {
+	buf   []byte
+	pos   int // start of the table
+	vt    int // start of its vtable
+	vtLen int
+}
+
+// fbUint32 reads a uint32 at pos, if it is within buf.
+func fbUint32(buf []byte, pos int64) (uint32, bool) {
+	if pos < 0 || pos+4 > int64(len(buf)) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(buf[pos:]), true
+}
+
+// fbRoot returns the root table of a flatbuffer.
+func fbRoot(buf []byte) (fbTable, error) {
+	off, ok := fbUint32(buf, 0)
+	if !ok {
+		return fbTable{}, errEnvelope
+	}
+	return fbTableAt(buf, int64(off))
+}
+
+// fbTableAt returns the table at pos.
+func fbTableAt(buf []byte, pos int64) (fbTable, error) {
+	so, ok := fbUint32(buf, pos)
+	if !ok {
+		return fbTable{}, errEnvelope
+	}
+	vt := pos - int64(int32(so))
+	if vt < 0 || vt+4 > int64(len(buf)) {
+		return fbTable{}, errEnvelope
+	}
+	vtLen := int64(binary.LittleEndian.Uint16(buf[vt:]))
+	if vtLen < 4 || vt+vtLen > int64(len(buf)) {
+		return fbTable{}, errEnvelope
+	}
+	return fbTable{buf: buf, pos: int(pos), vt: int(vt), vtLen: int(vtLen)}, nil
+}
+
+// field returns the position of a field, or -1 if it is absent.
+func (t fbTable) field(id int) int64 {
+	e := 4 + 2*id
+	if e+2 > t.vtLen {
+		return -1
+	}
+	off := binary.LittleEndian.Uint16(t.buf[t.vt+e:])
+	if off == 0 {
+		return -1
+	}
+	return int64(t.pos) + int64(off)
+}
+
+// bytes returns a [ubyte] field, or nil if it is absent.
+func (t fbTable) bytes(id int) ([]byte, error) {
+	p := t.field(id)
+	if p < 0 {
+		return nil, nil
+	}
+	off, ok := fbUint32(t.buf, p)
+	if !ok {
+		return nil, errEnvelope
+	}
+	vec := p + int64(off)
+	n, ok := fbUint32(t.buf, vec)
+	if !ok || vec+4+int64(n) > int64(len(t.buf)) {
+		return nil, errEnvelope
+	}
+	return t.buf[vec+4 : vec+4+int64(n)], nil
+}
+
+// uint8 returns a ubyte field, or 0 if it is absent.
+func (t fbTable) uint8(id int) (uint8, error) {
+	p := t.field(id)
+	if p < 0 {
+		return 0, nil
+	}
+	if p >= int64(len(t.buf)) {
+		return 0, errEnvelope
+	}
+	return t.buf[p], nil
+}
+
+// table returns a table field.
+func (t fbTable) table(id int) (fbTable, error) {
+	p := t.field(id)
+	if p < 0 {
+		return fbTable{}, errEnvelope
+	}
+	off, ok := fbUint32(t.buf, p)
+	if !ok {
+		return fbTable{}, errEnvelope
+	}
+	return fbTableAt(t.buf, p+int64(off))
+}
+
+// An fbField is a field of a table being written:  a [ubyte] vector,
+// a ubyte, or a nested table.  The zero fbField is absent.
+type fbField struct {
+	kind  int
+	vec   []byte
+	u8    uint8
+	table []fbField
+}
+
+const (
+	fbAbsent = iota
+	fbKindVector
+	fbKindUint8
+	fbKindTable
+)
+
+func fbVector(b []byte) fbField {
+	if b == nil {
+		return fbField{}
+	}
+	return fbField{kind: fbKindVector, vec: b}
+}
+
+func fbUint8(v uint8) fbField { return fbField{kind: fbKindUint8, u8: v} }
+
+func fbTableField(fields []fbField) fbField { return fbField{kind: fbKindTable, table: fields} }
+
+// fbBuild encodes a flatbuffer with the given root table.
+func fbBuild(root []fbField) []byte {
+	buf := make([]byte, 4)
+	buf, pos := fbAppendTable(buf, root)
+	binary.LittleEndian.PutUint32(buf, uint32(pos))
+	return buf
+}
+
+// fbPad pads buf to a multiple of 4 bytes.
+func fbPad(buf []byte) []byte {
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+// fbAppendTable appends a table (its vtable, then the table, then the
+// vectors and tables it refers to), returning the table's position.
+// Each present field gets a 4-byte slot in the table.
+func fbAppendTable(buf []byte, fields []fbField) ([]byte, int) {
+	buf = fbPad(buf)
+	vt := len(buf)
+	vtLen := 4 + 2*len(fields)
+	buf = append(buf, make([]byte, vtLen)...)
+	buf = fbPad(buf)
+	pos := len(buf)
+
+	slots := make([]int, len(fields))
+	n := 0
+	for i, f := range fields {
+		if f.kind == fbAbsent {
+			continue
+		}
+		n++
+		slots[i] = 4 * n
+		binary.LittleEndian.PutUint16(buf[vt+4+2*i:], uint16(slots[i]))
+	}
+	binary.LittleEndian.PutUint16(buf[vt:], uint16(vtLen))
+	binary.LittleEndian.PutUint16(buf[vt+2:], uint16(4+4*n))
+	buf = append(buf, make([]byte, 4+4*n)...)
+	binary.LittleEndian.PutUint32(buf[pos:], uint32(pos-vt))
+
+	for i, f := range fields {
+		slot := pos + slots[i]
+		switch f.kind {
+		case fbKindUint8:
+			buf[slot] = f.u8
+		case fbKindVector:
+			buf = fbPad(buf)
+			binary.LittleEndian.PutUint32(buf[slot:], uint32(len(buf)-slot))
+			var n [4]byte
+			binary.LittleEndian.PutUint32(n[:], uint32(len(f.vec)))
+			buf = append(buf, n[:]...)
+			buf = append(buf, f.vec...)
+		case fbKindTable:
+			var child int
+			buf, child = fbAppendTable(buf, f.table)
+			binary.LittleEndian.PutUint32(buf[slot:], uint32(child-slot))
+		}
+	}
+	return buf, pos
+}
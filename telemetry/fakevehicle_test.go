@@ -0,0 +1,88 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A FakeVehicle is a telemetry client that behaves like a vehicle,
+// for testing the Server.  It presents the client certificate in its
+// TLS configuration, and sends records over one websocket connection.
+type FakeVehicle struct {
+	VIN string
+
+	conn *websocket.Conn
+	txid int
+}
+
+// DialFakeVehicle connects to the telemetry server at url (a wss://
+// URL) as the vehicle with the given VIN.
+func DialFakeVehicle(ctx context.Context, url, vin string, config *tls.Config) (*FakeVehicle, error) {
+	dialer := websocket.Dialer{
+		TLSClientConfig:  config,
+		HandshakeTimeout: 30 * time.Second,
+	}
+	conn, _, err := dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &FakeVehicle{VIN: vin, conn: conn}, nil
+}
+
+// Send sends a record to the server, in an envelope with a new
+// transaction ID, which it returns.  If the record has no VIN or
+// creation time, the vehicle's VIN and the current time are used.
+func (v *FakeVehicle) Send(r *Record) ([]byte, error) {
+	if v.conn == nil {
+		return nil, errors.New("telemetry: fake vehicle not connected")
+	}
+	rec := *r
+	if rec.VIN == "" {
+		rec.VIN = v.VIN
+	}
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = time.Now()
+	}
+	v.txid++
+	m := StreamMessage{
+		TXID:     []byte(fmt.Sprintf("txid-%d", v.txid)),
+		Topic:    TopicVehicleData,
+		DeviceID: v.VIN,
+		Payload:  rec.Marshal(),
+	}
+	return m.TXID, v.conn.WriteMessage(websocket.BinaryMessage, m.Marshal())
+}
+
+// ReadAck waits up to timeout for an acknowledgement, and returns its
+// transaction ID.
+func (v *FakeVehicle) ReadAck(timeout time.Duration) ([]byte, error) {
+	v.conn.SetReadDeadline(time.Now().Add(timeout))
+	_, msg, err := v.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return parseStreamAck(msg)
+}
+
+// Close closes the connection to the server.
+func (v *FakeVehicle) Close() error {
+	if v.conn == nil {
+		return nil
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	v.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	return v.conn.Close()
+}
@@ -0,0 +1,496 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+// Package telemetry receives Fleet Telemetry from Tesla vehicles.
+//
+// Instead of being polled through vehicle_data, vehicles configured
+// for Fleet Telemetry connect to a server run by the application, over
+// websockets with mutual TLS, and push records of the fields they have
+// been asked to report.  Each binary websocket message is an envelope
+// carrying one record (a vehicle_data Payload protobuf), which the
+// server acknowledges.  A Server decodes these records and passes them
+// to a Sink, such as an InfluxSink.
+//
+// Records can be converted to the gotesla ChargeState, DriveState and
+// VehicleState types, for code that already works with vehicle_data.
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//
+// Telemetry records
+//
+// Only the handful of protobuf messages needed here are decoded, with
+// protowire, rather than pulling in generated code for the whole
+// vehicle_data schema.
+//
+
+// A Field identifies a telemetry field (the vehicle_data Field enum).
+type Field int32
+
+// Telemetry fields
+const (
+	FieldDriveState                 Field = 1
+	FieldChargeState                Field = 2
+	FieldVehicleSpeed               Field = 4
+	FieldOdometer                   Field = 5
+	FieldPackVoltage                Field = 6
+	FieldPackCurrent                Field = 7
+	FieldSoc                        Field = 8
+	FieldGear                       Field = 10
+	FieldLocation                   Field = 21
+	FieldGpsHeading                 Field = 23
+	FieldRatedRange                 Field = 32
+	FieldDCChargingPower            Field = 35
+	FieldACChargingPower            Field = 37
+	FieldChargeLimitSoc             Field = 38
+	FieldFastChargerPresent         Field = 39
+	FieldEstBatteryRange            Field = 40
+	FieldIdealBatteryRange          Field = 41
+	FieldBatteryLevel               Field = 42
+	FieldTimeToFullCharge           Field = 43
+	FieldScheduledChargingStartTime Field = 44
+	FieldScheduledChargingPending   Field = 45
+	FieldScheduledDepartureTime     Field = 46
+	FieldPreconditioningEnabled     Field = 47
+	FieldScheduledChargingMode      Field = 48
+	FieldChargeAmps                 Field = 49
+	FieldChargeEnableRequest        Field = 50
+	FieldChargerPhases              Field = 51
+	FieldChargePortColdWeatherMode  Field = 52
+	FieldChargeCurrentRequest       Field = 53
+	FieldChargeCurrentRequestMax    Field = 54
+	FieldBatteryHeaterOn            Field = 55
+	FieldNotEnoughPowerToHeat       Field = 56
+	FieldLocked                     Field = 59
+	FieldFdWindow                   Field = 60
+	FieldFpWindow                   Field = 61
+	FieldRdWindow                   Field = 62
+	FieldRpWindow                   Field = 63
+	FieldVehicleName                Field = 64
+	FieldSentryMode                 Field = 65
+	FieldVersion                    Field = 68
+)
+
+var fieldNames = map[Field]string{
+	FieldDriveState:                 "DriveState",
+	FieldChargeState:                "ChargeState",
+	FieldVehicleSpeed:               "VehicleSpeed",
+	FieldOdometer:                   "Odometer",
+	FieldPackVoltage:                "PackVoltage",
+	FieldPackCurrent:                "PackCurrent",
+	FieldSoc:                        "Soc",
+	FieldGear:                       "Gear",
+	FieldLocation:                   "Location",
+	FieldGpsHeading:                 "GpsHeading",
+	FieldRatedRange:                 "RatedRange",
+	FieldDCChargingPower:            "DCChargingPower",
+	FieldACChargingPower:            "ACChargingPower",
+	FieldChargeLimitSoc:             "ChargeLimitSoc",
+	FieldFastChargerPresent:         "FastChargerPresent",
+	FieldEstBatteryRange:            "EstBatteryRange",
+	FieldIdealBatteryRange:          "IdealBatteryRange",
+	FieldBatteryLevel:               "BatteryLevel",
+	FieldTimeToFullCharge:           "TimeToFullCharge",
+	FieldScheduledChargingStartTime: "ScheduledChargingStartTime",
+	FieldScheduledChargingPending:   "ScheduledChargingPending",
+	FieldScheduledDepartureTime:     "ScheduledDepartureTime",
+	FieldPreconditioningEnabled:     "PreconditioningEnabled",
+	FieldScheduledChargingMode:      "ScheduledChargingMode",
+	FieldChargeAmps:                 "ChargeAmps",
+	FieldChargeEnableRequest:        "ChargeEnableRequest",
+	FieldChargerPhases:              "ChargerPhases",
+	FieldChargePortColdWeatherMode:  "ChargePortColdWeatherMode",
+	FieldChargeCurrentRequest:       "ChargeCurrentRequest",
+	FieldChargeCurrentRequestMax:    "ChargeCurrentRequestMax",
+	FieldBatteryHeaterOn:            "BatteryHeaterOn",
+	FieldNotEnoughPowerToHeat:       "NotEnoughPowerToHeat",
+	FieldLocked:                     "Locked",
+	FieldFdWindow:                   "FdWindow",
+	FieldFpWindow:                   "FpWindow",
+	FieldRdWindow:                   "RdWindow",
+	FieldRpWindow:                   "RpWindow",
+	FieldVehicleName:                "VehicleName",
+	FieldSentryMode:                 "SentryMode",
+	FieldVersion:                    "Version",
+}
+
+// String returns the name of a Field, or its number if it is not one
+// we know about.
+func (f Field) String() string {
+	if s, ok := fieldNames[f]; ok {
+		return s
+	}
+	return "Field" + strconv.Itoa(int(f))
+}
+
+// A Kind is the type of a Value.
+type Kind int
+
+// Value kinds
+const (
+	KindInvalid Kind = iota
+	KindString
+	KindInt
+	KindFloat
+	KindBool
+	KindLocation
+)
+
+// A Location is a latitude and longitude, in degrees.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// A Value is the value of a telemetry field.  Vehicles report some
+// numeric fields as strings, so the accessors convert where they can.
+type Value struct {
+	Kind Kind
+
+	s   string
+	n   int64
+	f   float64
+	b   bool
+	loc Location
+}
+
+// StringValue returns a string Value.
+func StringValue(s string) Value { return Value{Kind: KindString, s: s} }
+
+// IntValue returns an integer Value.
+func IntValue(n int64) Value { return Value{Kind: KindInt, n: n} }
+
+// FloatValue returns a floating-point Value.
+func FloatValue(f float64) Value { return Value{Kind: KindFloat, f: f} }
+
+// BoolValue returns a boolean Value.
+func BoolValue(b bool) Value { return Value{Kind: KindBool, b: b} }
+
+// LocationValue returns a location Value.
+func LocationValue(lat, lon float64) Value {
+	return Value{Kind: KindLocation, loc: Location{Latitude: lat, Longitude: lon}}
+}
+
+// String returns a Value in string form.
+func (v Value) String() string {
+	switch v.Kind {
+	case KindString:
+		return v.s
+	case KindInt:
+		return strconv.FormatInt(v.n, 10)
+	case KindFloat:
+		return strconv.FormatFloat(v.f, 'g', -1, 64)
+	case KindBool:
+		return strconv.FormatBool(v.b)
+	case KindLocation:
+		return fmt.Sprintf("%g,%g", v.loc.Latitude, v.loc.Longitude)
+	}
+	return ""
+}
+
+// Int returns a Value as an integer.  ok is false if it has no
+// integer form.
+func (v Value) Int() (n int64, ok bool) {
+	switch v.Kind {
+	case KindInt:
+		return v.n, true
+	case KindFloat:
+		return int64(math.Round(v.f)), true
+	case KindString:
+		if f, err := strconv.ParseFloat(v.s, 64); err == nil {
+			return int64(math.Round(f)), true
+		}
+	}
+	return 0, false
+}
+
+// Float returns a Value as a floating-point number.  ok is false if it
+// has no numeric form.
+func (v Value) Float() (f float64, ok bool) {
+	switch v.Kind {
+	case KindInt:
+		return float64(v.n), true
+	case KindFloat:
+		return v.f, true
+	case KindString:
+		if f, err := strconv.ParseFloat(v.s, 64); err == nil {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// Bool returns a Value as a boolean.  ok is false if it has no
+// boolean form.
+func (v Value) Bool() (b bool, ok bool) {
+	switch v.Kind {
+	case KindBool:
+		return v.b, true
+	case KindString:
+		if b, err := strconv.ParseBool(v.s); err == nil {
+			return b, true
+		}
+	}
+	return false, false
+}
+
+// Location returns a location Value.
+func (v Value) Location() (loc Location, ok bool) {
+	return v.loc, v.Kind == KindLocation
+}
+
+// A Record is one message of telemetry from a vehicle.  Data holds the
+// fields that the vehicle reported; vehicles only send fields that
+// have changed.
+type Record struct {
+	VIN       string
+	CreatedAt time.Time
+	Resend    bool // a retransmission of an earlier record
+	Data      map[Field]Value
+}
+
+// Fields returns the fields in a Record, in numeric order.
+func (r *Record) Fields() []Field {
+	fields := make([]Field, 0, len(r.Data))
+	for f := range r.Data {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i] < fields[j] })
+	return fields
+}
+
+// Protobuf field numbers
+const (
+	payloadData      protowire.Number = 1
+	payloadCreatedAt protowire.Number = 2
+	payloadVIN       protowire.Number = 3
+	payloadIsResend  protowire.Number = 4
+
+	datumKey   protowire.Number = 1
+	datumValue protowire.Number = 2
+
+	valueString   protowire.Number = 1
+	valueInt      protowire.Number = 2
+	valueLong     protowire.Number = 3
+	valueFloat    protowire.Number = 4
+	valueDouble   protowire.Number = 5
+	valueBool     protowire.Number = 6
+	valueLocation protowire.Number = 7
+
+	locationLatitude  protowire.Number = 1
+	locationLongitude protowire.Number = 2
+
+	timestampSeconds protowire.Number = 1
+	timestampNanos   protowire.Number = 2
+)
+
+var errDecode = errors.New("malformed telemetry record")
+
+// Marshal encodes a Record as a vehicle_data Payload.
+func (r *Record) Marshal() []byte {
+	var b []byte
+	for _, f := range r.Fields() {
+		var d []byte
+		d = protowire.AppendTag(d, datumKey, protowire.VarintType)
+		d = protowire.AppendVarint(d, uint64(f))
+		d = protowire.AppendTag(d, datumValue, protowire.BytesType)
+		d = protowire.AppendBytes(d, r.Data[f].marshal())
+		b = protowire.AppendTag(b, payloadData, protowire.BytesType)
+		b = protowire.AppendBytes(b, d)
+	}
+	if !r.CreatedAt.IsZero() {
+		var t []byte
+		t = protowire.AppendTag(t, timestampSeconds, protowire.VarintType)
+		t = protowire.AppendVarint(t, uint64(r.CreatedAt.Unix()))
+		t = protowire.AppendTag(t, timestampNanos, protowire.VarintType)
+		t = protowire.AppendVarint(t, uint64(r.CreatedAt.Nanosecond()))
+		b = protowire.AppendTag(b, payloadCreatedAt, protowire.BytesType)
+		b = protowire.AppendBytes(b, t)
+	}
+	b = protowire.AppendTag(b, payloadVIN, protowire.BytesType)
+	b = protowire.AppendString(b, r.VIN)
+	if r.Resend {
+		b = protowire.AppendTag(b, payloadIsResend, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b
+}
+
+func (v Value) marshal() []byte {
+	var b []byte
+	switch v.Kind {
+	case KindString:
+		b = protowire.AppendTag(b, valueString, protowire.BytesType)
+		b = protowire.AppendString(b, v.s)
+	case KindInt:
+		b = protowire.AppendTag(b, valueLong, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.n))
+	case KindFloat:
+		b = protowire.AppendTag(b, valueDouble, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v.f))
+	case KindBool:
+		b = protowire.AppendTag(b, valueBool, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v.b))
+	case KindLocation:
+		var l []byte
+		l = protowire.AppendTag(l, locationLatitude, protowire.Fixed64Type)
+		l = protowire.AppendFixed64(l, math.Float64bits(v.loc.Latitude))
+		l = protowire.AppendTag(l, locationLongitude, protowire.Fixed64Type)
+		l = protowire.AppendFixed64(l, math.Float64bits(v.loc.Longitude))
+		b = protowire.AppendTag(b, valueLocation, protowire.BytesType)
+		b = protowire.AppendBytes(b, l)
+	}
+	return b
+}
+
+// ParseRecord decodes a vehicle_data Payload.  Values of kinds that we
+// don't understand are skipped.
+func ParseRecord(b []byte) (*Record, error) {
+	r := &Record{Data: make(map[Field]Value)}
+	err := forEachField(b, func(num protowire.Number, v uint64, bv []byte) error {
+		switch num {
+		case payloadData:
+			return r.parseDatum(bv)
+		case payloadCreatedAt:
+			var sec, nsec uint64
+			err := forEachField(bv, func(num protowire.Number, v uint64, bv []byte) error {
+				switch num {
+				case timestampSeconds:
+					sec = v
+				case timestampNanos:
+					nsec = v
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			r.CreatedAt = time.Unix(int64(sec), int64(nsec))
+		case payloadVIN:
+			r.VIN = string(bv)
+		case payloadIsResend:
+			r.Resend = v != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Record) parseDatum(b []byte) error {
+	var key Field
+	var val Value
+	err := forEachField(b, func(num protowire.Number, v uint64, bv []byte) error {
+		switch num {
+		case datumKey:
+			key = Field(v)
+		case datumValue:
+			var err error
+			val, err = parseValue(bv)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if val.Kind != KindInvalid {
+		r.Data[key] = val
+	}
+	return nil
+}
+
+func parseValue(b []byte) (Value, error) {
+	var val Value
+	err := forEachField(b, func(num protowire.Number, v uint64, bv []byte) error {
+		switch num {
+		case valueString:
+			val = StringValue(string(bv))
+		case valueInt:
+			val = IntValue(int64(int32(v)))
+		case valueLong:
+			val = IntValue(int64(v))
+		case valueFloat:
+			val = FloatValue(float64(math.Float32frombits(uint32(v))))
+		case valueDouble:
+			val = FloatValue(math.Float64frombits(v))
+		case valueBool:
+			val = BoolValue(v != 0)
+		case valueLocation:
+			var lat, lon float64
+			err := forEachField(bv, func(num protowire.Number, v uint64, bv []byte) error {
+				switch num {
+				case locationLatitude:
+					lat = math.Float64frombits(v)
+				case locationLongitude:
+					lon = math.Float64frombits(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			val = LocationValue(lat, lon)
+		}
+		return nil
+	})
+	return val, err
+}
+
+// forEachField calls f for each field in a protobuf message, with its
+// number and either its scalar value or its bytes.
+func forEachField(b []byte, f func(num protowire.Number, v uint64, bv []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errDecode
+		}
+		b = b[n:]
+
+		var v uint64
+		var bv []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			v = uint64(v32)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			bv, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errDecode
+		}
+		b = b[n:]
+		if typ == protowire.StartGroupType {
+			continue
+		}
+		err := f(num, v, bv)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
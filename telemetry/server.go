@@ -0,0 +1,198 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+//
+// Receiver
+//
+// Vehicles authenticate with client certificates, whose common name
+// is the VIN.  A Server accepts messages from a vehicle only for its
+// own VIN, and acknowledges each one once it has been handled.
+//
+
+// DefaultMaxRecordSize is the largest record a Server accepts by
+// default.
+const DefaultMaxRecordSize = 1 << 20
+
+// A Server is an http.Handler that accepts websocket connections from
+// vehicles and passes the records they send to Sink.  It must be
+// served over TLS with client certificates required (see
+// NewTLSConfig).
+type Server struct {
+	// Sink receives each record.
+	Sink Sink
+
+	// MaxRecordSize limits the size of a record; if zero,
+	// DefaultMaxRecordSize is used.
+	MaxRecordSize int64
+
+	// ErrorLog receives connection and decoding errors.  If nil,
+	// errors are logged with the log package's standard logger.
+	ErrorLog *log.Logger
+
+	upgrader websocket.Upgrader
+
+	mu    sync.Mutex
+	conns map[*websocket.Conn]bool
+}
+
+// NewTLSConfig returns a TLS configuration for a Server, using the
+// certificate and key in certFile and keyFile, and requiring vehicles
+// to present certificates issued by the CAs in caFile.
+func NewTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(body) {
+		return nil, errors.New(caFile + ": no certificates")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// ServeHTTP handles a connection from a vehicle.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+	vin := r.TLS.PeerCertificates[0].Subject.CommonName
+	if vin == "" {
+		http.Error(w, "no VIN in client certificate", http.StatusForbidden)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client
+		s.logf("telemetry: %s: %v", vin, err)
+		return
+	}
+	s.track(conn, true)
+	defer s.track(conn, false)
+	defer conn.Close()
+
+	limit := s.MaxRecordSize
+	if limit == 0 {
+		limit = DefaultMaxRecordSize
+	}
+	conn.SetReadLimit(limit)
+
+	ctx := r.Context()
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				s.logf("telemetry: %s: %v", vin, err)
+			}
+			return
+		}
+		if typ != websocket.BinaryMessage {
+			continue
+		}
+		m, err := ParseStreamMessage(msg)
+		if err != nil {
+			s.logf("telemetry: %s: %v", vin, err)
+			continue
+		}
+		if m.DeviceID != "" && m.DeviceID != vin {
+			s.logf("telemetry: %s: dropping message for VIN %q", vin, m.DeviceID)
+			continue
+		}
+		// Other topics are acknowledged, but otherwise ignored
+		if m.Topic == TopicVehicleData && !s.handleRecord(ctx, vin, m.Payload) {
+			continue
+		}
+		err = conn.WriteMessage(websocket.BinaryMessage, m.ack())
+		if err != nil {
+			s.logf("telemetry: %s: %v", vin, err)
+			return
+		}
+	}
+}
+
+// handleRecord passes a record from a vehicle to the Sink.  It returns
+// false (and the record shouldn't be acknowledged) if the record
+// couldn't be handled.
+func (s *Server) handleRecord(ctx context.Context, vin string, payload []byte) bool {
+	rec, err := ParseRecord(payload)
+	if err != nil {
+		s.logf("telemetry: %s: %v", vin, err)
+		return false
+	}
+	if rec.VIN != vin {
+		s.logf("telemetry: %s: dropping record for VIN %q", vin, rec.VIN)
+		return false
+	}
+	if s.Sink == nil {
+		return true
+	}
+	err = s.Sink.Write(ctx, rec)
+	if err != nil {
+		s.logf("telemetry: %s: %v", vin, err)
+		return false
+	}
+	return true
+}
+
+// track adds or removes a connection from the set of open connections.
+func (s *Server) track(conn *websocket.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[*websocket.Conn]bool)
+	}
+	if add {
+		s.conns[conn] = true
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// Close closes all open vehicle connections.  (Hijacked websocket
+// connections aren't closed by http.Server.Shutdown.)
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
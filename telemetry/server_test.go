@@ -0,0 +1,349 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPKI is a CA for the server's and vehicles' certificates.
+type testPKI struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pool   *x509.CertPool
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testPKI{cert: cert, key: key, pool: pool, serial: 1}
+}
+
+// issue returns a certificate for a server (on 127.0.0.1) or a
+// vehicle client with the given common name.
+func (p *testPKI) issue(t *testing.T, cn string, server bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.cert, &key.PublicKey, p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// testServer is a telemetry Server on a TLS httptest server, whose
+// Sink sends records to a channel.
+type testServer struct {
+	*httptest.Server
+	pki     *testPKI
+	records chan *Record
+}
+
+func newTestServer(t *testing.T) *testServer {
+	pki := newTestPKI(t)
+	ts := &testServer{pki: pki, records: make(chan *Record, 16)}
+	s := &Server{
+		Sink: SinkFunc(func(ctx context.Context, r *Record) error {
+			ts.records <- r
+			return nil
+		}),
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+	ts.Server = httptest.NewUnstartedServer(s)
+	ts.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "server", true)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool,
+	}
+	ts.StartTLS()
+	return ts
+}
+
+// dial connects to the server as a vehicle with a certificate for cn.
+func (ts *testServer) dial(t *testing.T, vin string, cn string) *FakeVehicle {
+	config := &tls.Config{
+		RootCAs:      ts.pki.pool,
+		Certificates: []tls.Certificate{ts.pki.issue(t, cn, false)},
+	}
+	url := "wss" + strings.TrimPrefix(ts.URL, "https")
+	v, err := DialFakeVehicle(context.Background(), url, vin, config)
+	if err != nil {
+		t.Fatalf("DialFakeVehicle: %v", err)
+	}
+	return v
+}
+
+func TestServerRoundTrip(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	v := ts.dial(t, "VIN1", "VIN1")
+	defer v.Close()
+
+	created := time.Unix(1600000000, 0)
+	txid, err := v.Send(&Record{
+		CreatedAt: created,
+		Data: map[Field]Value{
+			FieldBatteryLevel: IntValue(80),
+			FieldLocation:     LocationValue(37.5, -122.25),
+			FieldGear:         StringValue("D"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	ack, err := v.ReadAck(5 * time.Second)
+	if err != nil {
+		t.Fatalf("ReadAck: %v", err)
+	}
+	if !bytes.Equal(ack, txid) {
+		t.Errorf("got ack for %q, want %q", ack, txid)
+	}
+
+	select {
+	case r := <-ts.records:
+		if r.VIN != "VIN1" || !r.CreatedAt.Equal(created) {
+			t.Errorf("got record for %s at %v", r.VIN, r.CreatedAt)
+		}
+		if n, _ := r.Data[FieldBatteryLevel].Int(); n != 80 {
+			t.Errorf("got battery level %v", r.Data[FieldBatteryLevel])
+		}
+		if loc, _ := r.Data[FieldLocation].Location(); loc.Latitude != 37.5 || loc.Longitude != -122.25 {
+			t.Errorf("got location %v", r.Data[FieldLocation])
+		}
+	default:
+		t.Fatal("record not passed to sink")
+	}
+}
+
+func TestServerWrongVIN(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	// A vehicle's certificate doesn't let it send another's records
+	v := ts.dial(t, "VIN2", "VIN1")
+	defer v.Close()
+	_, err := v.Send(&Record{Data: map[Field]Value{FieldBatteryLevel: IntValue(80)}})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if ack, err := v.ReadAck(200 * time.Millisecond); err == nil {
+		t.Errorf("record for wrong VIN acknowledged (%q)", ack)
+	}
+	select {
+	case r := <-ts.records:
+		t.Errorf("record for %s passed to sink", r.VIN)
+	default:
+	}
+}
+
+func TestServerSinkFull(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	blocking := SinkFunc(func(ctx context.Context, r *Record) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	f := NewFanout(1, nil, blocking)
+	defer f.Close()
+	defer close(release)
+	ts.Config.Handler.(*Server).Sink = f
+
+	// The sink takes the first record and queues the second; the
+	// third is refused, so it isn't acknowledged
+	v := ts.dial(t, "VIN1", "VIN1")
+	defer v.Close()
+	var acked int
+	for i := 0; i < 3; i++ {
+		_, err := v.Send(&Record{Data: map[Field]Value{FieldBatteryLevel: IntValue(80)}})
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		if _, err := v.ReadAck(200 * time.Millisecond); err == nil {
+			acked++
+		}
+		if i == 0 {
+			<-started
+		}
+	}
+	if acked != 2 {
+		t.Errorf("got %d records acknowledged, want 2", acked)
+	}
+}
+
+func TestServerNoClientCert(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	url := "wss" + strings.TrimPrefix(ts.URL, "https")
+	_, err := DialFakeVehicle(context.Background(), url, "VIN1", &tls.Config{RootCAs: ts.pki.pool})
+	if err == nil {
+		t.Error("connected without a client certificate")
+	}
+
+	// Without TLS (for example, behind a misconfigured proxy), or
+	// with a certificate that has no VIN
+	s := &Server{ErrorLog: log.New(ioutil.Discard, "", 0)}
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("without TLS: got status %d", w.Code)
+	}
+	cert, err := x509.ParseCertificate(ts.pki.issue(t, "", false).Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("without VIN: got status %d", w.Code)
+	}
+}
+
+func TestStreamMessage(t *testing.T) {
+	m := StreamMessage{
+		TXID:      []byte("txid-1"),
+		Topic:     TopicVehicleData,
+		MessageID: []byte("msg-1"),
+		DeviceID:  "VIN1",
+		Payload:   []byte{0x0a, 0x00},
+	}
+	b := m.Marshal()
+	got, err := ParseStreamMessage(b)
+	if err != nil {
+		t.Fatalf("ParseStreamMessage: %v", err)
+	}
+	if !bytes.Equal(got.TXID, m.TXID) || got.Topic != m.Topic || !bytes.Equal(got.MessageID, m.MessageID) ||
+		got.DeviceID != m.DeviceID || !bytes.Equal(got.Payload, m.Payload) {
+		t.Errorf("got %+v", got)
+	}
+
+	txid, err := parseStreamAck(m.ack())
+	if err != nil || !bytes.Equal(txid, m.TXID) {
+		t.Errorf("parseStreamAck: got %q, %v", txid, err)
+	}
+	if _, err := ParseStreamMessage(m.ack()); err == nil {
+		t.Error("ParseStreamMessage accepted an ack")
+	}
+
+	// Truncated or corrupted envelopes are errors, not panics
+	for i := range b {
+		ParseStreamMessage(b[:i])
+		c := append([]byte{}, b...)
+		c[i] ^= 0xff
+		ParseStreamMessage(c)
+	}
+}
+
+func TestStreamMessageGolden(t *testing.T) {
+	// An envelope assembled by hand, with only message_type and a
+	// StreamMessage holding messageTopic and deviceID
+	golden := "10000000" + // root table at 16
+		"0c00 0c00 0000 0000 0400 0800" + // envelope vtable: fields 2, 3
+		"0c000000" + // envelope table: vtable at 16-12
+		"01000000" + // message_type StreamMessage
+		"14000000" + // message at 24+20
+		"1000 0c00 0000 0000 0400 0000 0000 0800" + // StreamMessage vtable: fields 2, 5
+		"10000000" + // StreamMessage table: vtable at 44-16
+		"08000000" + // messageTopic at 48+8
+		"0c000000" + // deviceID at 52+12
+		"01000000 56000000" + // "V"
+		"02000000 5631 0000" // "V1"
+	b, err := hex.DecodeString(strings.Replace(golden, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ParseStreamMessage(b)
+	if err != nil {
+		t.Fatalf("ParseStreamMessage: %v", err)
+	}
+	if m.Topic != TopicVehicleData || m.DeviceID != "V1" || m.TXID != nil || m.Payload != nil {
+		t.Errorf("got %+v", m)
+	}
+}
+
+func TestChargerPower(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[Field]Value
+		want int
+	}{
+		{"AC", map[Field]Value{FieldACChargingPower: FloatValue(7.2), FieldDCChargingPower: FloatValue(0)}, 7},
+		{"DC", map[Field]Value{FieldACChargingPower: FloatValue(0), FieldDCChargingPower: FloatValue(150)}, 150},
+		{"DC order", map[Field]Value{FieldDCChargingPower: FloatValue(150), FieldACChargingPower: FloatValue(0)}, 150},
+		{"fast charger", map[Field]Value{FieldACChargingPower: FloatValue(1), FieldDCChargingPower: FloatValue(120), FieldFastChargerPresent: BoolValue(true)}, 120},
+		{"AC only", map[Field]Value{FieldACChargingPower: FloatValue(11)}, 11},
+	}
+	for _, tt := range tests {
+		r := &Record{Data: tt.data}
+		cs := r.ChargeState()
+		if cs == nil || cs.ChargerPower != tt.want {
+			t.Errorf("%s: got %+v, want charger power %d", tt.name, cs, tt.want)
+		}
+	}
+}
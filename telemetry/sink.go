@@ -0,0 +1,199 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	influxClient "github.com/influxdata/influxdb1-client/v2" // too many things called "client"
+)
+
+//
+// Sinks
+//
+// A Sink consumes telemetry records.  A Fanout passes each record to
+// several sinks, so that one slow sink (say, a database that is down)
+// doesn't hold up the vehicles' connections or the other sinks.
+//
+
+// A Sink consumes telemetry records.
+type Sink interface {
+	Write(ctx context.Context, r *Record) error
+}
+
+// SinkFunc adapts an ordinary function to a Sink.
+type SinkFunc func(ctx context.Context, r *Record) error
+
+// Write calls f(ctx, r).
+func (f SinkFunc) Write(ctx context.Context, r *Record) error {
+	return f(ctx, r)
+}
+
+// An InfluxSink writes records to an InfluxDB database, as one point
+// per record, tagged with the VIN.  Each telemetry field becomes a
+// field of the point, named as in the vehicle_data schema (locations
+// become Latitude and Longitude fields).
+type InfluxSink struct {
+	Client      influxClient.Client
+	Database    string
+	Measurement string
+}
+
+// Write writes a record to InfluxDB.
+func (s *InfluxSink) Write(ctx context.Context, r *Record) error {
+	fields := make(map[string]interface{}, len(r.Data))
+	for f, v := range r.Data {
+		switch v.Kind {
+		case KindString:
+			fields[f.String()] = v.s
+		case KindInt:
+			fields[f.String()] = v.n
+		case KindFloat:
+			fields[f.String()] = v.f
+		case KindBool:
+			fields[f.String()] = v.b
+		case KindLocation:
+			fields["Latitude"] = v.loc.Latitude
+			fields["Longitude"] = v.loc.Longitude
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	bp, err := influxClient.NewBatchPoints(influxClient.BatchPointsConfig{
+		Database:  s.Database,
+		Precision: "ms",
+	})
+	if err != nil {
+		return err
+	}
+	pt, err := influxClient.NewPoint(
+		s.Measurement,
+		map[string]string{"vin": r.VIN},
+		fields,
+		r.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	bp.AddPoint(pt)
+	return s.Client.Write(bp)
+}
+
+// DefaultFanoutBuffer is the number of records a Fanout queues for
+// each sink.
+const DefaultFanoutBuffer = 256
+
+// ErrFanoutClosed is returned when writing to a closed Fanout.
+var ErrFanoutClosed = errors.New("telemetry: fanout closed")
+
+// ErrFanoutFull is returned when a Fanout can't queue a record
+// because a sink's queue is full.
+var ErrFanoutFull = errors.New("telemetry: sink queue full")
+
+// A Fanout is a Sink that passes records to several other sinks.  Each
+// sink has its own queue and goroutine.  When any sink's queue is
+// full, a record isn't queued for any of them, and Write returns
+// ErrFanoutFull rather than waiting; the Server then doesn't
+// acknowledge the record, so the vehicle sends it again later.
+type Fanout struct {
+	dropped uint64 // first, for 64-bit alignment of atomic access
+
+	errorLog *log.Logger
+	queues   []chan *Record
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewFanout returns a Fanout to sinks, queueing up to buffer records
+// (at least one) for each.  Errors from the sinks are logged to errorLog, or if it is
+// nil, with the log package's standard logger.
+func NewFanout(buffer int, errorLog *log.Logger, sinks ...Sink) *Fanout {
+	f := &Fanout{errorLog: errorLog}
+	if buffer < 1 {
+		buffer = 1
+	}
+	for _, s := range sinks {
+		q := make(chan *Record, buffer)
+		f.queues = append(f.queues, q)
+		f.wg.Add(1)
+		go f.run(s, q)
+	}
+	return f
+}
+
+// run writes queued records to a sink until the queue is closed.
+func (f *Fanout) run(s Sink, q chan *Record) {
+	defer f.wg.Done()
+	for r := range q {
+		err := s.Write(context.Background(), r)
+		if err != nil {
+			f.logf("telemetry: sink: %v", err)
+		}
+	}
+}
+
+func (f *Fanout) logf(format string, args ...interface{}) {
+	if f.errorLog != nil {
+		f.errorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// Write queues a record for each sink, or if any sink's queue is full,
+// for none of them.  It doesn't wait for the sinks to write it.
+func (f *Fanout) Write(ctx context.Context, r *Record) error {
+	// Writes are serialized, so that once every queue has room,
+	// none can fill up before the record is queued.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return ErrFanoutClosed
+	}
+	for _, q := range f.queues {
+		if len(q) == cap(q) {
+			atomic.AddUint64(&f.dropped, 1)
+			return ErrFanoutFull
+		}
+	}
+	for _, q := range f.queues {
+		q <- r
+	}
+	return nil
+}
+
+// Dropped returns the number of records refused with ErrFanoutFull.
+func (f *Fanout) Dropped() uint64 {
+	return atomic.LoadUint64(&f.dropped)
+}
+
+// Close stops accepting records, and waits for the sinks to write
+// those already queued.
+func (f *Fanout) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	for _, q := range f.queues {
+		close(q)
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	return nil
+}
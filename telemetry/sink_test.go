@@ -0,0 +1,97 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFanout(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	ok := SinkFunc(func(ctx context.Context, r *Record) error {
+		mu.Lock()
+		got = append(got, r.VIN)
+		mu.Unlock()
+		return nil
+	})
+	failing := SinkFunc(func(ctx context.Context, r *Record) error {
+		return errors.New("sink failed")
+	})
+
+	var buf bytes.Buffer
+	f := NewFanout(DefaultFanoutBuffer, log.New(&buf, "", 0), ok, failing)
+	for _, vin := range []string{"VIN1", "VIN2"} {
+		if err := f.Write(context.Background(), &Record{VIN: vin}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	f.Close()
+
+	if len(got) != 2 || got[0] != "VIN1" || got[1] != "VIN2" {
+		t.Errorf("got records %v", got)
+	}
+	if n := strings.Count(buf.String(), "sink failed"); n != 2 {
+		t.Errorf("got %d errors logged:\n%s", n, buf.String())
+	}
+	if err := f.Write(context.Background(), &Record{}); err != ErrFanoutClosed {
+		t.Errorf("Write after Close: got %v", err)
+	}
+}
+
+func TestFanoutFull(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	wrote := make(chan string, 3)
+	blocking := SinkFunc(func(ctx context.Context, r *Record) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	ok := SinkFunc(func(ctx context.Context, r *Record) error {
+		wrote <- r.VIN
+		return nil
+	})
+	f := NewFanout(1, nil, blocking, ok)
+
+	// The first record is being written by the blocking sink (and
+	// has been by the other), and the second fills its queue
+	if err := f.Write(context.Background(), &Record{VIN: "VIN1"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	<-started
+	got := []string{<-wrote}
+	if err := f.Write(context.Background(), &Record{VIN: "VIN2"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := f.Write(context.Background(), &Record{VIN: "VIN3"}); err != ErrFanoutFull {
+		t.Errorf("Write to full queue: got %v, want ErrFanoutFull", err)
+	}
+	if n := f.Dropped(); n != 1 {
+		t.Errorf("got %d dropped, want 1", n)
+	}
+
+	close(release)
+	f.Close()
+	close(wrote)
+	for vin := range wrote {
+		got = append(got, vin)
+	}
+
+	// The refused record wasn't passed to the other sink either
+	if len(got) != 2 || got[0] != "VIN1" || got[1] != "VIN2" {
+		t.Errorf("got records %v", got)
+	}
+}
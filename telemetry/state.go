@@ -0,0 +1,167 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package telemetry
+
+import (
+	"github.com/bmah888/gotesla"
+)
+
+//
+// Conversion to vehicle_data types
+//
+// Each table maps a telemetry field to a setter on the corresponding
+// gotesla struct.  Fields that a vehicle didn't report (or that can't
+// be converted) are left at their zero values.
+//
+
+func intOf(v Value) int {
+	n, _ := v.Int()
+	return int(n)
+}
+
+func floatOf(v Value) float64 {
+	f, _ := v.Float()
+	return f
+}
+
+func boolOf(v Value) bool {
+	b, _ := v.Bool()
+	return b
+}
+
+var chargeFields = map[Field]func(*gotesla.ChargeState, Value){
+	FieldBatteryHeaterOn:            func(cs *gotesla.ChargeState, v Value) { cs.BatteryHeaterOn = boolOf(v) },
+	FieldBatteryLevel:               func(cs *gotesla.ChargeState, v Value) { cs.BatteryLevel = intOf(v) },
+	FieldRatedRange:                 func(cs *gotesla.ChargeState, v Value) { cs.BatteryRange = floatOf(v) },
+	FieldChargeCurrentRequest:       func(cs *gotesla.ChargeState, v Value) { cs.ChargeCurrentRequest = intOf(v) },
+	FieldChargeCurrentRequestMax:    func(cs *gotesla.ChargeState, v Value) { cs.ChargeCurrentRequestMax = intOf(v) },
+	FieldChargeEnableRequest:        func(cs *gotesla.ChargeState, v Value) { cs.ChargeEnableRequest = boolOf(v) },
+	FieldChargeLimitSoc:             func(cs *gotesla.ChargeState, v Value) { cs.ChargeLimitSoc = intOf(v) },
+	FieldChargePortColdWeatherMode:  func(cs *gotesla.ChargeState, v Value) { cs.ChargePortColdWeatherMode = boolOf(v) },
+	FieldChargeAmps:                 func(cs *gotesla.ChargeState, v Value) { cs.ChargerActualCurrent = intOf(v) },
+	FieldChargerPhases:              func(cs *gotesla.ChargeState, v Value) { cs.ChargerPhases = intOf(v) },
+	FieldChargeState:                func(cs *gotesla.ChargeState, v Value) { cs.ChargingState = v.String() },
+	FieldEstBatteryRange:            func(cs *gotesla.ChargeState, v Value) { cs.EstBatteryRange = floatOf(v) },
+	FieldFastChargerPresent:         func(cs *gotesla.ChargeState, v Value) { cs.FastChargerPresent = boolOf(v) },
+	FieldIdealBatteryRange:          func(cs *gotesla.ChargeState, v Value) { cs.IdealBatteryRange = floatOf(v) },
+	FieldNotEnoughPowerToHeat:       func(cs *gotesla.ChargeState, v Value) { cs.NotEnoughPowerToHeat = boolOf(v) },
+	FieldPreconditioningEnabled:     func(cs *gotesla.ChargeState, v Value) { cs.PreconditioningEnabled = boolOf(v) },
+	FieldScheduledChargingMode:      func(cs *gotesla.ChargeState, v Value) { cs.ScheduledChargingMode = v.String() },
+	FieldScheduledChargingPending:   func(cs *gotesla.ChargeState, v Value) { cs.ScheduledChargingPending = boolOf(v) },
+	FieldScheduledChargingStartTime: func(cs *gotesla.ChargeState, v Value) { cs.ScheduledChargingStartTime = intOf(v) },
+	FieldScheduledDepartureTime:     func(cs *gotesla.ChargeState, v Value) { cs.ScheduledDepartureTime = intOf(v) },
+	FieldTimeToFullCharge:           func(cs *gotesla.ChargeState, v Value) { cs.TimeToFullCharge = floatOf(v) },
+
+	// Charger power is set by chargerPower
+	FieldACChargingPower: func(cs *gotesla.ChargeState, v Value) {},
+	FieldDCChargingPower: func(cs *gotesla.ChargeState, v Value) {},
+}
+
+// chargerPower returns the charger power in a Record, in whole kW as
+// vehicle_data reports it.  vehicle_data has only one charger power,
+// but telemetry reports AC and DC power separately (and a vehicle
+// might report both, one of them zero), so DC power is used while
+// fast charging, and otherwise whichever is non-zero.
+func (r *Record) chargerPower() int {
+	ac := intOf(r.Data[FieldACChargingPower])
+	dc := intOf(r.Data[FieldDCChargingPower])
+	fast, ok := r.Data[FieldFastChargerPresent]
+	if ok && boolOf(fast) {
+		if _, ok := r.Data[FieldDCChargingPower]; ok {
+			return dc
+		}
+	}
+	if ac != 0 {
+		return ac
+	}
+	return dc
+}
+
+var driveFields = map[Field]func(*gotesla.DriveState, Value){
+	FieldGpsHeading:   func(ds *gotesla.DriveState, v Value) { ds.Heading = intOf(v) },
+	FieldGear:         func(ds *gotesla.DriveState, v Value) { ds.ShiftState = v.String() },
+	FieldVehicleSpeed: func(ds *gotesla.DriveState, v Value) { ds.Speed = floatOf(v) }, // as decoded from JSON
+	FieldLocation: func(ds *gotesla.DriveState, v Value) {
+		if loc, ok := v.Location(); ok {
+			ds.Latitude = loc.Latitude
+			ds.Longitude = loc.Longitude
+		}
+	},
+}
+
+var vehicleFields = map[Field]func(*gotesla.VehicleState, Value){
+	FieldVersion:     func(vs *gotesla.VehicleState, v Value) { vs.CarVersion = v.String() },
+	FieldFdWindow:    func(vs *gotesla.VehicleState, v Value) { vs.FdWindow = intOf(v) },
+	FieldFpWindow:    func(vs *gotesla.VehicleState, v Value) { vs.FpWindow = intOf(v) },
+	FieldRdWindow:    func(vs *gotesla.VehicleState, v Value) { vs.RdWindow = intOf(v) },
+	FieldRpWindow:    func(vs *gotesla.VehicleState, v Value) { vs.RpWindow = intOf(v) },
+	FieldLocked:      func(vs *gotesla.VehicleState, v Value) { vs.Locked = boolOf(v) },
+	FieldOdometer:    func(vs *gotesla.VehicleState, v Value) { vs.Odometer = floatOf(v) },
+	FieldSentryMode:  func(vs *gotesla.VehicleState, v Value) { vs.SentryMode = boolOf(v) },
+	FieldVehicleName: func(vs *gotesla.VehicleState, v Value) { vs.VehicleName = v.String() },
+}
+
+// timeStamp returns the record's creation time in milliseconds, the
+// units of the vehicle_data timestamps.
+func (r *Record) timeStamp() int {
+	return int(r.CreatedAt.UnixNano() / 1e6)
+}
+
+// ChargeState returns the charging fields in a Record as a ChargeState,
+// or nil if the Record has none.
+func (r *Record) ChargeState() *gotesla.ChargeState {
+	var cs *gotesla.ChargeState
+	for _, f := range r.Fields() {
+		if set, ok := chargeFields[f]; ok {
+			if cs == nil {
+				cs = &gotesla.ChargeState{TimeStamp: r.timeStamp()}
+			}
+			set(cs, r.Data[f])
+		}
+	}
+	if cs != nil {
+		cs.ChargerPower = r.chargerPower()
+	}
+	return cs
+}
+
+// DriveState returns the driving fields in a Record as a DriveState,
+// or nil if the Record has none.
+func (r *Record) DriveState() *gotesla.DriveState {
+	var ds *gotesla.DriveState
+	for _, f := range r.Fields() {
+		if set, ok := driveFields[f]; ok {
+			if ds == nil {
+				ds = &gotesla.DriveState{TimeStamp: r.timeStamp()}
+			}
+			set(ds, r.Data[f])
+		}
+	}
+	if ds != nil {
+		if _, ok := r.Data[FieldLocation]; ok {
+			ds.GpsAsOf = int(r.CreatedAt.Unix())
+		}
+	}
+	return ds
+}
+
+// VehicleState returns the vehicle fields in a Record as a
+// VehicleState, or nil if the Record has none.
+func (r *Record) VehicleState() *gotesla.VehicleState {
+	var vs *gotesla.VehicleState
+	for _, f := range r.Fields() {
+		if set, ok := vehicleFields[f]; ok {
+			if vs == nil {
+				vs = &gotesla.VehicleState{TimeStamp: r.timeStamp()}
+			}
+			set(vs, r.Data[f])
+		}
+	}
+	return vs
+}
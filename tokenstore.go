@@ -0,0 +1,323 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+//
+// Token storage
+//
+
+// A TokenStore saves and loads a Token.
+type TokenStore interface {
+	Load() (*Token, error)
+	Save(t *Token) error
+	Delete() error
+}
+
+// ErrReadOnlyStore is returned when trying to save or delete a token
+// in a TokenStore that can't be modified.
+var ErrReadOnlyStore = errors.New("token store is read-only")
+
+// WithTokenStore sets the TokenStore used by GetAndCacheToken and
+// RefreshAndCacheToken.  The default is the default account in the
+// token cache at TokenCachePath (as of the time the token is saved).
+func WithTokenStore(store TokenStore) ClientOption {
+	return func(c *Client) {
+		c.tokenStore = store
+	}
+}
+
+// store returns the Client's TokenStore.
+func (c *Client) store() TokenStore {
+	if c.tokenStore == nil {
+		return NewAccountTokenStore(TokenCachePath, DefaultAccount)
+	}
+	return c.tokenStore
+}
+
+// writeFileAtomic writes data to a temporary file and if that succeeds,
+// moves it atomically into place.  The file is readable only by its
+// owner, even if a stale temporary file was left with other permissions.
+func writeFileAtomic(path string, data []byte) error {
+	err := ioutil.WriteFile(path+TokenCachePathNewSuffix, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(path+TokenCachePathNewSuffix, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+TokenCachePathNewSuffix, path)
+}
+
+// A FileTokenStore keeps a single Token as plaintext JSON in a file.
+// (The token cache at TokenCachePath can hold several accounts; see
+// AccountTokenStore.)
+type FileTokenStore struct {
+	Path string
+}
+
+// NewFileTokenStore returns a FileTokenStore for the given file.
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{Path: path}
+}
+
+// Load reads the Token from the file.
+func (s *FileTokenStore) Load() (*Token, error) {
+	var t Token
+
+	body, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Save writes the Token to the file.
+func (s *FileTokenStore) Save(t *Token) error {
+	tokenJSON, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, tokenJSON)
+}
+
+// Delete removes the file.
+func (s *FileTokenStore) Delete() error {
+	return os.Remove(s.Path)
+}
+
+// DefaultTokenEnv is the environment variable conventionally used
+// with an EnvTokenStore.
+const DefaultTokenEnv = "TESLA_TOKEN"
+
+// An EnvTokenStore reads a Token from an environment variable, which
+// holds either the Token's JSON representation or just an access
+// token.  It is read-only.
+type EnvTokenStore struct {
+	Name string
+}
+
+// NewEnvTokenStore returns an EnvTokenStore for the given variable.
+func NewEnvTokenStore(name string) *EnvTokenStore {
+	return &EnvTokenStore{Name: name}
+}
+
+// Load reads the Token from the environment.
+func (s *EnvTokenStore) Load() (*Token, error) {
+	value := strings.TrimSpace(os.Getenv(s.Name))
+	if value == "" {
+		return nil, fmt.Errorf("%s: %w", s.Name, os.ErrNotExist)
+	}
+	if !strings.HasPrefix(value, "{") {
+		return &Token{AccessToken: value, TokenType: "bearer"}, nil
+	}
+
+	var t Token
+	err := json.Unmarshal([]byte(value), &t)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	return &t, nil
+}
+
+// Save returns ErrReadOnlyStore.
+func (s *EnvTokenStore) Save(t *Token) error {
+	return ErrReadOnlyStore
+}
+
+// Delete returns ErrReadOnlyStore.
+func (s *EnvTokenStore) Delete() error {
+	return ErrReadOnlyStore
+}
+
+// scrypt parameters for new EncryptedFileTokenStore files.
+const (
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32 // AES-256
+)
+
+// Limits on the scrypt parameters read from a file, so that a corrupt
+// or tampered file can't make Load use unbounded memory or CPU time.
+// scrypt needs 128*N*R bytes of memory.
+const (
+	scryptMaxN   = 1 << 20
+	scryptMaxMem = 1 << 30
+	scryptMaxRP  = 1 << 30
+)
+
+// ErrNoPassphrase is returned by an EncryptedFileTokenStore with an
+// empty passphrase.
+var ErrNoPassphrase = errors.New("token store passphrase is empty")
+
+// checkScryptParams returns an error unless the scrypt parameters in
+// a file are within sane limits.
+func (et *encryptedToken) checkScryptParams() error {
+	if et.N <= 1 || et.N > scryptMaxN || et.N&(et.N-1) != 0 {
+		return fmt.Errorf("scrypt N %d out of range", et.N)
+	}
+	if et.R < 1 || et.P < 1 || et.R*et.P >= scryptMaxRP {
+		return fmt.Errorf("scrypt r %d, p %d out of range", et.R, et.P)
+	}
+	if uint64(128)*uint64(et.N)*uint64(et.R) > scryptMaxMem {
+		return fmt.Errorf("scrypt N %d, r %d need too much memory", et.N, et.R)
+	}
+	if len(et.Salt) == 0 {
+		return errors.New("no scrypt salt")
+	}
+	return nil
+}
+
+// encryptedToken is the file format of an EncryptedFileTokenStore.
+// The scrypt parameters are kept in the file, so they can be changed
+// without making old files unreadable.
+type encryptedToken struct {
+	Version    int    `json:"version"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// An EncryptedFileTokenStore keeps a Token in a file, encrypted with
+// AES-GCM using a key derived from a passphrase with scrypt.  The
+// passphrase must not be empty.
+type EncryptedFileTokenStore struct {
+	Path       string
+	Passphrase string
+}
+
+// NewEncryptedFileTokenStore returns an EncryptedFileTokenStore for
+// the given file and passphrase.
+func NewEncryptedFileTokenStore(path string, passphrase string) *EncryptedFileTokenStore {
+	return &EncryptedFileTokenStore{Path: path, Passphrase: passphrase}
+}
+
+// aead returns the AES-GCM cipher for the given key derivation
+// parameters.
+func (s *EncryptedFileTokenStore) aead(et *encryptedToken) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(s.Passphrase), et.Salt, et.N, et.R, et.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Load reads and decrypts the Token.
+func (s *EncryptedFileTokenStore) Load() (*Token, error) {
+	var et encryptedToken
+
+	if s.Passphrase == "" {
+		return nil, ErrNoPassphrase
+	}
+	body, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, &et)
+	if err != nil {
+		return nil, err
+	}
+	if et.Version != 1 {
+		return nil, fmt.Errorf("%s: unknown version %d", s.Path, et.Version)
+	}
+	err = et.checkScryptParams()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+
+	aead, err := s.aead(&et)
+	if err != nil {
+		return nil, err
+	}
+	if len(et.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%s: bad nonce", s.Path)
+	}
+	tokenJSON, err := aead.Open(nil, et.Nonce, et.Ciphertext, nil)
+	if err != nil {
+		// Almost certainly the wrong passphrase
+		return nil, fmt.Errorf("%s: can't decrypt token", s.Path)
+	}
+
+	var t Token
+	err = json.Unmarshal(tokenJSON, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Save encrypts and writes the Token, with a new salt and nonce.
+func (s *EncryptedFileTokenStore) Save(t *Token) error {
+	if s.Passphrase == "" {
+		return ErrNoPassphrase
+	}
+	tokenJSON, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	et := encryptedToken{
+		Version: 1,
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, 16),
+	}
+	_, err = rand.Read(et.Salt)
+	if err != nil {
+		return err
+	}
+	aead, err := s.aead(&et)
+	if err != nil {
+		return err
+	}
+	et.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(et.Nonce)
+	if err != nil {
+		return err
+	}
+	et.Ciphertext = aead.Seal(nil, et.Nonce, tokenJSON, nil)
+
+	body, err := json.Marshal(&et)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, body)
+}
+
+// Delete removes the file.
+func (s *EncryptedFileTokenStore) Delete() error {
+	return os.Remove(s.Path)
+}
@@ -0,0 +1,97 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedFileTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotesla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token.enc")
+
+	s := NewEncryptedFileTokenStore(path, "correct horse")
+	err = s.Save(&Token{AccessToken: "access", RefreshToken: "refresh"})
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	tok, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if tok.AccessToken != "access" || tok.RefreshToken != "refresh" {
+		t.Errorf("got token %+v", tok)
+	}
+
+	_, err = NewEncryptedFileTokenStore(path, "wrong").Load()
+	if err == nil {
+		t.Error("Load succeeded with wrong passphrase")
+	}
+	_, err = NewEncryptedFileTokenStore(path, "").Load()
+	if !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Load with empty passphrase: got %v", err)
+	}
+	err = NewEncryptedFileTokenStore(path, "").Save(tok)
+	if !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Save with empty passphrase: got %v", err)
+	}
+}
+
+func TestEncryptedFileTokenStoreBadParams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotesla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token.enc")
+
+	s := NewEncryptedFileTokenStore(path, "correct horse")
+	err = s.Save(&Token{AccessToken: "access"})
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		n, r, p int
+	}{
+		{"N too big", 1 << 30, 8, 1},
+		{"N not a power of two", 30000, 8, 1},
+		{"r*p too big", 1024, 1 << 16, 1 << 14},
+		{"too much memory", 1 << 20, 16, 1},
+		{"zero p", 1024, 8, 0},
+	}
+	for _, tt := range tests {
+		var et encryptedToken
+		json.Unmarshal(body, &et)
+		et.N, et.R, et.P = tt.n, tt.r, tt.p
+		tampered, _ := json.Marshal(&et)
+		err = ioutil.WriteFile(path, tampered, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.Load()
+		if err == nil {
+			t.Errorf("%s: Load succeeded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//
+// Software updates
+//
+
+// SoftwareUpdateStatus summarizes the state of a software update.
+type SoftwareUpdateStatus int
+
+// SoftwareUpdateStatus values
+const (
+	SoftwareUpdateNone SoftwareUpdateStatus = iota
+	SoftwareUpdateAvailable
+	SoftwareUpdateDownloading
+	SoftwareUpdateScheduled
+	SoftwareUpdateInstalling
+	SoftwareUpdateUnknown
+)
+
+// String returns a human-readable SoftwareUpdateStatus.
+func (s SoftwareUpdateStatus) String() string {
+	switch s {
+	case SoftwareUpdateNone:
+		return "none"
+	case SoftwareUpdateAvailable:
+		return "available"
+	case SoftwareUpdateDownloading:
+		return "downloading"
+	case SoftwareUpdateScheduled:
+		return "scheduled"
+	case SoftwareUpdateInstalling:
+		return "installing"
+	}
+	return "unknown"
+}
+
+// UpdateStatus interprets the Status string of a software update.
+// Variants like "downloading_wifi_wait" map to the closest value.
+func (su *VehicleStateSoftwareUpdate) UpdateStatus() SoftwareUpdateStatus {
+	switch {
+	case su.Status == "":
+		return SoftwareUpdateNone
+	case su.Status == "available":
+		return SoftwareUpdateAvailable
+	case strings.HasPrefix(su.Status, "downloading"):
+		return SoftwareUpdateDownloading
+	case su.Status == "scheduled":
+		return SoftwareUpdateScheduled
+	case strings.HasPrefix(su.Status, "installing"):
+		return SoftwareUpdateInstalling
+	}
+	return SoftwareUpdateUnknown
+}
+
+// ScheduledTime returns the time a software update is scheduled to
+// be installed, and true if one is scheduled.
+func (su *VehicleStateSoftwareUpdate) ScheduledTime() (time.Time, bool) {
+	if su.UpdateStatus() != SoftwareUpdateScheduled || su.ScheduledTimeMs == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, su.ScheduledTimeMs*int64(time.Millisecond)), true
+}
+
+// ScheduleSoftwareUpdate schedules installation of an available
+// software update, to start after the given delay.  A delay of zero
+// starts the installation immediately.
+func (c *Client) ScheduleSoftwareUpdate(ctx context.Context, ids string, delay time.Duration) (*CommandResult, error) {
+	if delay < 0 {
+		return nil, fmt.Errorf("negative software update delay %v", delay)
+	}
+	params := struct {
+		OffsetSec int64 `json:"offset_sec"`
+	}{int64(delay / time.Second)}
+	return c.PostCommand(ctx, ids, "schedule_software_update", &params)
+}
+
+// ScheduleSoftwareUpdateAt schedules installation of an available
+// software update at (approximately) the given time.  Times in the
+// past start the installation immediately.
+func (c *Client) ScheduleSoftwareUpdateAt(ctx context.Context, ids string, t time.Time) (*CommandResult, error) {
+	delay := time.Until(t)
+	if delay < 0 {
+		delay = 0
+	}
+	return c.ScheduleSoftwareUpdate(ctx, ids, delay)
+}
+
+// CancelSoftwareUpdate cancels a scheduled software update.
+func (c *Client) CancelSoftwareUpdate(ctx context.Context, ids string) (*CommandResult, error) {
+	return c.PostCommand(ctx, ids, "cancel_software_update", nil)
+}
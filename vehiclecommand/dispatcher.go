@@ -0,0 +1,260 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+// Package vehiclecommand sends end-to-end authenticated commands to
+// Tesla vehicles, using the vehicle command protocol.
+//
+// Newer vehicles ignore unsigned REST commands.  Instead, a client
+// whose public key has been paired with the vehicle establishes a
+// session with each vehicle domain (security and infotainment), and
+// then sends commands that are encrypted (or authenticated) with the
+// session key.  Messages are carried by a Transport, normally the
+// Fleet API signed_command endpoint (see FleetTransport).
+//
+// Command payloads are the serialized domain messages (for example,
+// a car_server Action for the infotainment domain); encoding those
+// is up to the caller.  Commands sent through a FleetTransport are
+// checked with its gotesla.Client's CommandGuard before they are
+// signed.
+package vehiclecommand
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bmah888/gotesla"
+)
+
+// A Transport carries an encoded message to a vehicle, and returns
+// the vehicle's encoded response.
+type Transport interface {
+	RoundTrip(ctx context.Context, req []byte) ([]byte, error)
+}
+
+// An Error is a fault reported by a vehicle.
+type Error struct {
+	Domain Domain
+	Status OperationStatus
+	Fault  Fault
+}
+
+// Error returns a string representation of an Error.
+func (e *Error) Error() string {
+	if e.Fault == FaultNone {
+		return fmt.Sprintf("%s: operation status %d", e.Domain, e.Status)
+	}
+	return fmt.Sprintf("%s: %s", e.Domain, e.Fault)
+}
+
+// DefaultCommandTTL is how long a command remains valid, if it is
+// delayed on the way to the vehicle.
+const DefaultCommandTTL = 15 * time.Second
+
+// A Dispatcher sends commands to one vehicle, establishing sessions
+// with its domains as needed.  It is safe for concurrent use.
+type Dispatcher struct {
+	// TTL is how long each command remains valid.
+	TTL time.Duration
+
+	// Authenticate makes the Dispatcher authenticate commands with
+	// an HMAC rather than encrypting them.  (Responses are still
+	// encrypted.)
+	Authenticate bool
+
+	vin       string
+	key       *ecdsa.PrivateKey
+	transport Transport
+	address   []byte // our routing address
+
+	mu       sync.Mutex
+	sessions map[Domain]*Session
+}
+
+// NewDispatcher returns a Dispatcher that sends commands to the
+// vehicle with the given VIN through transport, signed with key.
+func NewDispatcher(vin string, key *ecdsa.PrivateKey, transport Transport) (*Dispatcher, error) {
+	address, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return &Dispatcher{
+		TTL:       DefaultCommandTTL,
+		vin:       vin,
+		key:       key,
+		transport: transport,
+		address:   address,
+		sessions:  make(map[Domain]*Session),
+	}, nil
+}
+
+// roundTrip sends a message and decodes the response.
+func (d *Dispatcher) roundTrip(ctx context.Context, msg *routableMessage) (*routableMessage, error) {
+	body, err := d.transport.RoundTrip(ctx, msg.marshal())
+	if err != nil {
+		return nil, err
+	}
+	var resp routableMessage
+	err = resp.unmarshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.fromDomain != msg.toDomain {
+		return nil, fmt.Errorf("response from %s, expected %s", resp.fromDomain, msg.toDomain)
+	}
+	if resp.requestUUID != nil && !bytes.Equal(resp.requestUUID, msg.uuid) {
+		return nil, errors.New("response to a different request")
+	}
+	return &resp, nil
+}
+
+// StartSession does a handshake with a vehicle domain, replacing any
+// existing session with it.
+func (d *Dispatcher) StartSession(ctx context.Context, domain Domain) (*Session, error) {
+	challenge, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	msg := routableMessage{
+		toDomain:           domain,
+		fromAddress:        d.address,
+		sessionInfoRequest: PublicKeyBytes(&d.key.PublicKey),
+		uuid:               challenge,
+	}
+	resp, err := d.roundTrip(ctx, &msg)
+	if err != nil {
+		return nil, err
+	}
+	if resp.status == OperationError || resp.fault != FaultNone {
+		return nil, &Error{Domain: domain, Status: resp.status, Fault: resp.fault}
+	}
+	if resp.sessionInfo == nil || resp.sig == nil || resp.sig.kind != sigFieldSessionInfoTag {
+		return nil, errors.New("no session info in response")
+	}
+
+	s, err := newSession(d.key, d.vin, domain, resp.sessionInfo, resp.sig.tag, challenge)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.sessions[domain] = s
+	d.mu.Unlock()
+	return s, nil
+}
+
+// session returns the session with a domain, starting one if needed.
+func (d *Dispatcher) session(ctx context.Context, domain Domain) (*Session, error) {
+	d.mu.Lock()
+	s := d.sessions[domain]
+	d.mu.Unlock()
+	if s != nil {
+		return s, nil
+	}
+	return d.StartSession(ctx, domain)
+}
+
+// Send sends a command to a vehicle domain, and returns the (decrypted)
+// response payload.  If the vehicle reports that the session is out of
+// date (for example, because it has rebooted), a new session is started
+// and the command is sent once more.  Faults reported by the vehicle
+// are returned as an *Error.
+//
+// The command is named (as in the owner API, for example "door_lock")
+// for the CommandGuard of the Dispatcher's FleetTransport, if it has
+// one, which is keyed by VIN.  In dry-run mode, nothing is signed or
+// sent, and the response payload is nil.  The payload is binary, and
+// may include a PIN that can't be picked out to redact, so the audit
+// log records its domain and SHA-256 digest instead (see
+// auditParams).
+func (d *Dispatcher) Send(ctx context.Context, domain Domain, command string, payload []byte) ([]byte, error) {
+	t, ok := d.transport.(*FleetTransport)
+	if !ok || t.Client == nil {
+		return d.send(ctx, domain, payload)
+	}
+
+	params, err := auditParams(domain, payload)
+	if err != nil {
+		return nil, err
+	}
+	var resp []byte
+	_, err = t.Client.GuardCommand(t.VIN, command, params, func() (*gotesla.CommandResult, error) {
+		var err error
+		resp, err = d.send(ctx, domain, payload)
+		if err != nil {
+			return nil, err
+		}
+		return &gotesla.CommandResult{Result: true, Command: command}, nil
+	})
+	return resp, err
+}
+
+// auditParams returns the parameters recorded in the audit log for a
+// command payload.
+func auditParams(domain Domain, payload []byte) ([]byte, error) {
+	sum := sha256.Sum256(payload)
+	return json.Marshal(&struct {
+		Domain        string `json:"domain"`
+		PayloadSHA256 string `json:"payload_sha256"`
+	}{domain.String(), hex.EncodeToString(sum[:])})
+}
+
+// send signs and sends a command, for Send.
+func (d *Dispatcher) send(ctx context.Context, domain Domain, payload []byte) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		s, err := d.session(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		uuid, err := randomBytes(16)
+		if err != nil {
+			return nil, err
+		}
+		msg := routableMessage{
+			toDomain:    domain,
+			fromAddress: d.address,
+			uuid:        uuid,
+		}
+		var reqHash []byte
+		if d.Authenticate {
+			reqHash, err = s.authenticate(&msg, payload, d.TTL)
+		} else {
+			reqHash, err = s.encrypt(&msg, payload, d.TTL)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := d.roundTrip(ctx, &msg)
+		if err != nil {
+			return nil, err
+		}
+		if resp.fault != FaultNone {
+			if resp.fault.resync() && attempt == 1 {
+				d.mu.Lock()
+				if d.sessions[domain] == s {
+					delete(d.sessions, domain)
+				}
+				d.mu.Unlock()
+				continue
+			}
+			return nil, &Error{Domain: domain, Status: resp.status, Fault: resp.fault}
+		}
+		if resp.status == OperationError {
+			return nil, &Error{Domain: domain, Status: resp.status}
+		}
+		return s.openResponse(resp, reqHash)
+	}
+}
@@ -0,0 +1,248 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package vehiclecommand
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bmah888/gotesla"
+)
+
+// newTestDispatcher returns a Dispatcher for a FakeVehicle that echoes
+// commands back, with the Dispatcher's key paired (if paired is true).
+func newTestDispatcher(t *testing.T, paired bool) (*Dispatcher, *FakeVehicle) {
+	v, err := NewFakeVehicle(testVIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Handler = func(domain Domain, payload []byte) ([]byte, error) {
+		return append([]byte("ok:"), payload...), nil
+	}
+	key, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paired {
+		v.Pair(&key.PublicKey)
+	}
+	d, err := NewDispatcher(testVIN, key, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, v
+}
+
+func TestDispatcherSend(t *testing.T) {
+	for _, authenticate := range []bool{false, true} {
+		d, _ := newTestDispatcher(t, true)
+		d.Authenticate = authenticate
+		for i := 0; i < 3; i++ {
+			resp, err := d.Send(context.Background(), DomainInfotainment, "charge_start", []byte("cmd"))
+			if err != nil {
+				t.Fatalf("authenticate %v: Send: %v", authenticate, err)
+			}
+			if string(resp) != "ok:cmd" {
+				t.Errorf("authenticate %v: got response %q", authenticate, resp)
+			}
+		}
+	}
+}
+
+func TestDispatcherResync(t *testing.T) {
+	d, v := newTestDispatcher(t, true)
+	_, err := d.Send(context.Background(), DomainVehicleSecurity, "door_lock", []byte("cmd"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	s := d.sessions[DomainVehicleSecurity]
+
+	// After a reboot, the old session's epoch is rejected, and a
+	// new session is started
+	err = v.Reboot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Send(context.Background(), DomainVehicleSecurity, "door_lock", []byte("cmd"))
+	if err != nil {
+		t.Fatalf("Send after reboot: %v", err)
+	}
+	if d.sessions[DomainVehicleSecurity] == s {
+		t.Error("session not replaced after reboot")
+	}
+}
+
+func TestDispatcherUnpaired(t *testing.T) {
+	d, _ := newTestDispatcher(t, false)
+	_, err := d.Send(context.Background(), DomainInfotainment, "charge_start", []byte("cmd"))
+	var e *Error
+	if !errors.As(err, &e) || e.Fault != FaultUnknownKeyID {
+		t.Errorf("got error %v, want FaultUnknownKeyID", err)
+	}
+}
+
+// fleetVehicle serves the Fleet API signed_command endpoint for a
+// FakeVehicle.
+type fleetVehicle struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests int
+}
+
+func newFleetVehicle(v *FakeVehicle) *fleetVehicle {
+	f := &fleetVehicle{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/1/vehicles/"+v.VIN+"/signed_command" {
+			http.NotFound(w, r)
+			return
+		}
+		f.mu.Lock()
+		f.requests++
+		f.mu.Unlock()
+
+		var req struct {
+			RoutableMessage string `json:"routable_message"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		msg, err := base64.StdEncoding.DecodeString(req.RoutableMessage)
+		if err != nil {
+			http.Error(w, "bad message", http.StatusBadRequest)
+			return
+		}
+		resp, err := v.RoundTrip(r.Context(), msg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"response": base64.StdEncoding.EncodeToString(resp)})
+	}))
+	return f
+}
+
+func TestDispatcherGuard(t *testing.T) {
+	v, err := NewFakeVehicle(testVIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Pair(&key.PublicKey)
+	v.Handler = func(domain Domain, payload []byte) ([]byte, error) {
+		return append([]byte("ok:"), payload...), nil
+	}
+	f := newFleetVehicle(v)
+	defer f.Close()
+
+	tests := []struct {
+		name     string
+		guard    *gotesla.CommandGuard
+		wantErr  error
+		response []byte
+		requests int // session handshake and command
+	}{
+		{"no guard", nil, nil, []byte("ok:cmd"), 2},
+		{"allowed", &gotesla.CommandGuard{Policy: gotesla.CommandPolicy{testVIN: {"door_lock"}}}, nil, []byte("ok:cmd"), 2},
+		{"not allowed", &gotesla.CommandGuard{Policy: gotesla.CommandPolicy{testVIN: {"charge_start"}}}, gotesla.ErrCommandNotAllowed, nil, 0},
+		{"dry run", &gotesla.CommandGuard{DryRun: true}, nil, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []gotesla.ClientOption{gotesla.WithBaseURL(f.URL), gotesla.WithRetryPolicy(gotesla.NoRetryPolicy)}
+			if tt.guard != nil {
+				opts = append(opts, gotesla.WithCommandGuard(tt.guard))
+			}
+			transport := &FleetTransport{Client: gotesla.NewClient(opts...), VIN: testVIN}
+			d, err := NewDispatcher(testVIN, key, transport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f.mu.Lock()
+			f.requests = 0
+			f.mu.Unlock()
+			resp, err := d.Send(context.Background(), DomainVehicleSecurity, "door_lock", []byte("cmd"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Send: got error %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(resp, tt.response) {
+				t.Errorf("got response %q, want %q", resp, tt.response)
+			}
+			if f.requests != tt.requests {
+				t.Errorf("got %d requests, want %d", f.requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestDispatcherAudit(t *testing.T) {
+	v, err := NewFakeVehicle(testVIN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Pair(&key.PublicKey)
+	f := newFleetVehicle(v)
+	defer f.Close()
+
+	dir, err := ioutil.TempDir("", "vehiclecommand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	al, err := gotesla.OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer al.Close()
+
+	guard := &gotesla.CommandGuard{DryRun: true, AuditLog: al}
+	c := gotesla.NewClient(gotesla.WithBaseURL(f.URL), gotesla.WithCommandGuard(guard))
+	d, err := NewDispatcher(testVIN, key, &FleetTransport{Client: c, VIN: testVIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Send(context.Background(), DomainVehicleSecurity, "door_lock", []byte("cmd"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// The audit record identifies the payload without revealing it
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec gotesla.AuditRecord
+	if err := json.Unmarshal(body, &rec); err != nil {
+		t.Fatalf("bad audit record %q: %v", body, err)
+	}
+	sum := sha256.Sum256([]byte("cmd"))
+	want := `{"domain":"vehicle security","payload_sha256":"` + hex.EncodeToString(sum[:]) + `"}`
+	if rec.Command != "door_lock" || string(rec.Params) != want {
+		t.Errorf("got audit record %s", body)
+	}
+}
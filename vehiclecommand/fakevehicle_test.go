@@ -0,0 +1,191 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package vehiclecommand
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// A FakeVehicle is an in-process vehicle that speaks the vehicle
+// command protocol, for testing the Dispatcher.  It implements
+// Transport, so it can be passed to NewDispatcher.
+//
+// Commands that pass authentication are passed to Handler (if set),
+// whose result is encrypted and sent back as the response.  An error
+// from Handler is reported as FaultInvalidCommand.
+type FakeVehicle struct {
+	VIN     string
+	Handler func(domain Domain, payload []byte) ([]byte, error)
+
+	mu       sync.Mutex
+	key      *ecdsa.PrivateKey
+	epoch    []byte
+	start    time.Time
+	paired   map[string]bool
+	counters map[string]uint32 // last counter, by domain and key
+	sent     uint32            // response counter
+}
+
+// NewFakeVehicle returns a FakeVehicle with the given VIN, and no
+// paired keys.
+func NewFakeVehicle(vin string) (*FakeVehicle, error) {
+	key, err := GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	v := &FakeVehicle{
+		VIN:    vin,
+		key:    key,
+		paired: make(map[string]bool),
+	}
+	err = v.Reboot()
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Pair adds a public key to the vehicle's keychain.
+func (v *FakeVehicle) Pair(pub *ecdsa.PublicKey) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.paired[hex.EncodeToString(PublicKeyBytes(pub))] = true
+}
+
+// Reboot starts a new epoch, invalidating existing sessions.
+func (v *FakeVehicle) Reboot() error {
+	epoch, err := randomBytes(16)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.epoch = epoch
+	v.start = time.Now()
+	v.counters = make(map[string]uint32)
+	return nil
+}
+
+// clock returns the vehicle's clock, in seconds since the epoch began.
+// Must be called with v.mu held.
+func (v *FakeVehicle) clock() uint32 {
+	return uint32(time.Since(v.start) / time.Second)
+}
+
+// RoundTrip handles a message, and returns the vehicle's response.
+func (v *FakeVehicle) RoundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	var msg routableMessage
+	err := msg.unmarshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := routableMessage{
+		toAddress:   msg.fromAddress,
+		fromDomain:  msg.toDomain,
+		requestUUID: msg.uuid,
+		flags:       msg.flags,
+	}
+	fault := v.handle(&msg, &resp)
+	if fault != FaultNone {
+		resp = routableMessage{
+			toAddress:   msg.fromAddress,
+			fromDomain:  msg.toDomain,
+			requestUUID: msg.uuid,
+			status:      OperationError,
+			fault:       fault,
+		}
+	}
+	return resp.marshal(), nil
+}
+
+// handle processes a message, filling in the response.
+func (v *FakeVehicle) handle(msg *routableMessage, resp *routableMessage) Fault {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if msg.toDomain != DomainVehicleSecurity && msg.toDomain != DomainInfotainment {
+		return FaultInvalidDomains
+	}
+
+	// Session handshake
+	if msg.sessionInfoRequest != nil {
+		id := hex.EncodeToString(msg.sessionInfoRequest)
+		if !v.paired[id] {
+			return FaultUnknownKeyID
+		}
+		key, err := sharedKey(v.key, msg.sessionInfoRequest)
+		if err != nil {
+			return FaultBadParameter
+		}
+		info := sessionInfo{
+			counter:   v.counters[msg.toDomain.String()+id],
+			publicKey: PublicKeyBytes(&v.key.PublicKey),
+			epoch:     v.epoch,
+			clockTime: v.clock(),
+		}
+		meta, err := sessionInfoMetadata(v.VIN, msg.uuid)
+		if err != nil {
+			return FaultInternal
+		}
+		resp.sessionInfo = info.marshal()
+		resp.sig = &signatureData{
+			kind: sigFieldSessionInfoTag,
+			tag:  hmacSHA256(hmacSHA256(key, []byte(labelSessionInfo)), meta, resp.sessionInfo),
+		}
+		return FaultNone
+	}
+
+	// Command
+	sig := msg.sig
+	if sig == nil {
+		return FaultInvalidSignature
+	}
+	id := hex.EncodeToString(sig.publicKey)
+	if !v.paired[id] {
+		return FaultUnknownKeyID
+	}
+	key, err := sharedKey(v.key, sig.publicKey)
+	if err != nil {
+		return FaultBadParameter
+	}
+	if string(sig.epoch) != string(v.epoch) {
+		return FaultIncorrectEpoch
+	}
+	if sig.expiresAt < v.clock() {
+		return FaultTimeExpired
+	}
+	counterKey := msg.toDomain.String() + id
+	if sig.counter <= v.counters[counterKey] {
+		return FaultInvalidTokenOrCounter
+	}
+	payload, reqHash, err := openCommand(key, v.VIN, msg)
+	if err != nil {
+		return FaultInvalidSignature
+	}
+	v.counters[counterKey] = sig.counter
+
+	var result []byte
+	if v.Handler != nil {
+		result, err = v.Handler(msg.toDomain, payload)
+		if err != nil {
+			return FaultInvalidCommand
+		}
+	}
+	v.sent++
+	err = sealResponse(key, v.VIN, resp, v.sent, reqHash, result)
+	if err != nil {
+		return FaultInternal
+	}
+	return FaultNone
+}
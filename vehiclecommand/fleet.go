@@ -0,0 +1,48 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package vehiclecommand
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/bmah888/gotesla"
+)
+
+// A FleetTransport carries messages to a vehicle through the Fleet API
+// signed_command endpoint.  Client should be in Fleet API mode (see
+// gotesla.WithFleetAPI), authenticated with the vehicle owner's token.
+type FleetTransport struct {
+	Client *gotesla.Client
+	VIN    string
+}
+
+// RoundTrip sends a message to the vehicle and returns its response.
+func (t *FleetTransport) RoundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	payload, err := json.Marshal(map[string]string{
+		"routable_message": base64.StdEncoding.EncodeToString(req),
+	})
+	if err != nil {
+		return nil, err
+	}
+	body, err := t.Client.PostTesla(ctx, "/api/1/vehicles/"+t.VIN+"/signed_command", payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Response string `json:"response"`
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Response)
+}
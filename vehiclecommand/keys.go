@@ -0,0 +1,169 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package vehiclecommand
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/bmah888/gotesla"
+)
+
+//
+// Key management
+//
+// Commands are signed with an EC P-256 key, whose public key has been
+// paired with the vehicle (and, for Fleet API applications, published
+// at the application's domain).
+//
+
+// GeneratePrivateKey makes a new P-256 private key.
+func GeneratePrivateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// LoadPrivateKey reads a PEM-encoded P-256 private key, in either SEC 1
+// ("EC PRIVATE KEY") or PKCS #8 ("PRIVATE KEY") form.
+func LoadPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New(path + ": no PEM data")
+	}
+
+	var key *ecdsa.PrivateKey
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var k interface{}
+		k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key, ok = k.(*ecdsa.PrivateKey); !ok {
+				err = errors.New(path + ": not an EC private key")
+			}
+		}
+	default:
+		err = errors.New(path + ": unexpected PEM type " + block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New(path + ": not a P-256 key")
+	}
+	return key, nil
+}
+
+// SavePrivateKey writes a private key in SEC 1 PEM form, readable only
+// by its owner.  Like the token cache, it writes a new file and moves
+// it atomically into place.
+func SavePrivateKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	body := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	err = ioutil.WriteFile(path+".new", body, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".new", path)
+}
+
+// PublicKeyBytes returns a public key as an uncompressed EC point, the
+// form used in the protocol (and by the Fleet API).
+func PublicKeyBytes(pub *ecdsa.PublicKey) []byte {
+	return elliptic.Marshal(elliptic.P256(), pub.X, pub.Y)
+}
+
+// PublicKeyPEM returns a public key in PEM (PKIX) form.
+func PublicKeyPEM(pub *ecdsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePublicKeyPEM decodes a PEM (PKIX) P-256 public key.
+func ParsePublicKeyPEM(body []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := k.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != elliptic.P256() {
+		return nil, errors.New("not a P-256 public key")
+	}
+	return pub, nil
+}
+
+// FetchPublicKey retrieves the public key that a Fleet API application
+// publishes at gotesla.PublicKeyPath on its domain.  If client is nil,
+// http.DefaultClient is used.
+func FetchPublicKey(ctx context.Context, client *http.Client, domain string) (*ecdsa.PublicKey, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	url := "https://" + domain + gotesla.PublicKeyPath
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ParsePublicKeyPEM(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	return pub, nil
+}
+
+// sharedKey computes the session key shared between our private key
+// and the other party's public key (an uncompressed EC point):  the
+// first 16 bytes of the SHA-1 hash of the ECDH shared secret.
+func sharedKey(priv *ecdsa.PrivateKey, peer []byte) ([]byte, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), peer)
+	if x == nil {
+		return nil, errors.New("invalid public key")
+	}
+	sx, _ := elliptic.P256().ScalarMult(x, y, priv.D.Bytes())
+	shared := make([]byte, 32)
+	b := sx.Bytes()
+	copy(shared[len(shared)-len(b):], b)
+	return sessionKey(shared), nil
+}
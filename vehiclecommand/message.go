@@ -0,0 +1,472 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package vehiclecommand
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+//
+// Protocol messages
+//
+// Only the parts of the vehicle command protocol's messages that are
+// needed here are encoded and decoded, directly with protowire.  The
+// field numbers are those of universal_message.proto and
+// signatures.proto.
+//
+
+// A Domain is a vehicle subsystem that accepts commands.
+type Domain int
+
+// Domain values
+const (
+	DomainBroadcast       Domain = 0
+	DomainVehicleSecurity Domain = 2 // VCSEC: locks, closures, keys
+	DomainInfotainment    Domain = 3 // everything else
+)
+
+// String returns the name of a Domain.
+func (d Domain) String() string {
+	switch d {
+	case DomainBroadcast:
+		return "broadcast"
+	case DomainVehicleSecurity:
+		return "vehicle security"
+	case DomainInfotainment:
+		return "infotainment"
+	}
+	return fmt.Sprintf("domain %d", int(d))
+}
+
+// OperationStatus is the overall status of a message.
+type OperationStatus int
+
+// OperationStatus values
+const (
+	OperationOK    OperationStatus = 0
+	OperationWait  OperationStatus = 1
+	OperationError OperationStatus = 2
+)
+
+// A Fault is the reason a vehicle rejected a message.
+type Fault int
+
+// Fault values
+const (
+	FaultNone                  Fault = 0
+	FaultBusy                  Fault = 1
+	FaultTimeout               Fault = 2
+	FaultUnknownKeyID          Fault = 3
+	FaultInactiveKey           Fault = 4
+	FaultInvalidSignature      Fault = 5
+	FaultInvalidTokenOrCounter Fault = 6
+	FaultInsufficientPrivilege Fault = 7
+	FaultInvalidDomains        Fault = 8
+	FaultInvalidCommand        Fault = 9
+	FaultDecoding              Fault = 10
+	FaultInternal              Fault = 11
+	FaultWrongPersonalization  Fault = 12
+	FaultBadParameter          Fault = 13
+	FaultKeychainIsFull        Fault = 14
+	FaultIncorrectEpoch        Fault = 15
+	FaultIVIncorrectLength     Fault = 16
+	FaultTimeExpired           Fault = 17
+)
+
+var faultNames = map[Fault]string{
+	FaultNone:                  "none",
+	FaultBusy:                  "busy",
+	FaultTimeout:               "timeout",
+	FaultUnknownKeyID:          "unknown key (not paired with vehicle?)",
+	FaultInactiveKey:           "inactive key",
+	FaultInvalidSignature:      "invalid signature",
+	FaultInvalidTokenOrCounter: "invalid token or counter",
+	FaultInsufficientPrivilege: "insufficient privileges",
+	FaultInvalidDomains:        "invalid domain",
+	FaultInvalidCommand:        "invalid command",
+	FaultDecoding:              "decoding error",
+	FaultInternal:              "internal error",
+	FaultWrongPersonalization:  "wrong VIN",
+	FaultBadParameter:          "bad parameter",
+	FaultKeychainIsFull:        "keychain is full",
+	FaultIncorrectEpoch:        "incorrect epoch",
+	FaultIVIncorrectLength:     "nonce has incorrect length",
+	FaultTimeExpired:           "command expired",
+}
+
+// String returns a description of a Fault.
+func (f Fault) String() string {
+	if s, ok := faultNames[f]; ok {
+		return s
+	}
+	return fmt.Sprintf("fault %d", int(f))
+}
+
+// resync returns true if a Fault means that the session is out of
+// sync with the vehicle, so a new handshake might fix it.
+func (f Fault) resync() bool {
+	switch f {
+	case FaultInvalidSignature, FaultInvalidTokenOrCounter, FaultIncorrectEpoch, FaultTimeExpired:
+		return true
+	}
+	return false
+}
+
+// SignatureData field numbers for the kinds of signature
+const (
+	sigFieldAESGCMPersonalized   = 5
+	sigFieldSessionInfoTag       = 6
+	sigFieldHMACPersonalized     = 8
+	sigFieldAESGCMResponse       = 9
+	sigFieldSignerIdentity       = 1
+	signerIdentityFieldPublicKey = 1
+)
+
+// signatureData is a SignatureData message.  Which fields are used
+// depends on kind (one of the sigField constants).
+type signatureData struct {
+	kind      int
+	publicKey []byte // signer identity
+	epoch     []byte
+	nonce     []byte
+	counter   uint32
+	expiresAt uint32
+	tag       []byte
+}
+
+// routableMessage is a RoutableMessage, the envelope for everything
+// exchanged with a vehicle.
+type routableMessage struct {
+	toDomain    Domain
+	toAddress   []byte // routing address, instead of a domain
+	fromDomain  Domain
+	fromAddress []byte
+
+	payload            []byte // protobuf_message_as_bytes
+	sessionInfoRequest []byte // our public key, if requesting session info
+	sessionInfo        []byte
+
+	sig *signatureData
+
+	status OperationStatus
+	fault  Fault
+
+	requestUUID []byte // in a response, the uuid of the request
+	uuid        []byte
+	flags       uint32
+}
+
+// sessionInfo is the vehicle's half of a session handshake.
+type sessionInfo struct {
+	counter   uint32
+	publicKey []byte
+	epoch     []byte
+	clockTime uint32
+	status    int
+}
+
+// appendDestination appends a Destination message.
+func appendDestination(b []byte, num protowire.Number, domain Domain, address []byte) []byte {
+	var d []byte
+	if address != nil {
+		d = protowire.AppendTag(d, 2, protowire.BytesType)
+		d = protowire.AppendBytes(d, address)
+	} else {
+		d = protowire.AppendTag(d, 1, protowire.VarintType)
+		d = protowire.AppendVarint(d, uint64(domain))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, d)
+}
+
+// appendBytesField appends a bytes field, if v is non-empty.
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendVarintField appends a varint field, if v is non-zero.
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendFixed32Field appends a fixed32 field, if v is non-zero.
+func appendFixed32Field(b []byte, num protowire.Number, v uint32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, v)
+}
+
+// marshal encodes a SignatureData.
+func (s *signatureData) marshal() []byte {
+	var id []byte
+	id = appendBytesField(id, signerIdentityFieldPublicKey, s.publicKey)
+
+	var sig []byte
+	switch s.kind {
+	case sigFieldAESGCMPersonalized:
+		sig = appendBytesField(sig, 1, s.epoch)
+		sig = appendBytesField(sig, 2, s.nonce)
+		sig = appendVarintField(sig, 3, uint64(s.counter))
+		sig = appendFixed32Field(sig, 4, s.expiresAt)
+		sig = appendBytesField(sig, 5, s.tag)
+	case sigFieldHMACPersonalized:
+		sig = appendBytesField(sig, 1, s.epoch)
+		sig = appendVarintField(sig, 2, uint64(s.counter))
+		sig = appendFixed32Field(sig, 3, s.expiresAt)
+		sig = appendBytesField(sig, 4, s.tag)
+	case sigFieldAESGCMResponse:
+		sig = appendBytesField(sig, 1, s.nonce)
+		sig = appendVarintField(sig, 2, uint64(s.counter))
+		sig = appendBytesField(sig, 3, s.tag)
+	case sigFieldSessionInfoTag:
+		sig = appendBytesField(sig, 1, s.tag)
+	}
+
+	var b []byte
+	if id != nil {
+		b = protowire.AppendTag(b, sigFieldSignerIdentity, protowire.BytesType)
+		b = protowire.AppendBytes(b, id)
+	}
+	b = protowire.AppendTag(b, protowire.Number(s.kind), protowire.BytesType)
+	return protowire.AppendBytes(b, sig)
+}
+
+// marshal encodes a RoutableMessage.
+func (m *routableMessage) marshal() []byte {
+	var b []byte
+	b = appendDestination(b, 6, m.toDomain, m.toAddress)
+	b = appendDestination(b, 7, m.fromDomain, m.fromAddress)
+	b = appendBytesField(b, 10, m.payload)
+	if m.status != OperationOK || m.fault != FaultNone {
+		var st []byte
+		st = appendVarintField(st, 1, uint64(m.status))
+		st = appendVarintField(st, 2, uint64(m.fault))
+		b = protowire.AppendTag(b, 12, protowire.BytesType)
+		b = protowire.AppendBytes(b, st)
+	}
+	if m.sig != nil {
+		b = protowire.AppendTag(b, 13, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.sig.marshal())
+	}
+	if m.sessionInfoRequest != nil {
+		var req []byte
+		req = appendBytesField(req, 1, m.sessionInfoRequest)
+		b = protowire.AppendTag(b, 14, protowire.BytesType)
+		b = protowire.AppendBytes(b, req)
+	}
+	b = appendBytesField(b, 15, m.sessionInfo)
+	b = appendBytesField(b, 50, m.requestUUID)
+	b = appendBytesField(b, 51, m.uuid)
+	b = appendVarintField(b, 52, uint64(m.flags))
+	return b
+}
+
+// marshal encodes a SessionInfo.
+func (si *sessionInfo) marshal() []byte {
+	var b []byte
+	b = appendVarintField(b, 1, uint64(si.counter))
+	b = appendBytesField(b, 2, si.publicKey)
+	b = appendBytesField(b, 3, si.epoch)
+	b = appendFixed32Field(b, 4, si.clockTime)
+	b = appendVarintField(b, 5, uint64(si.status))
+	return b
+}
+
+var errDecode = errors.New("malformed message")
+
+// forEachField calls f for each field in an encoded message.  Values
+// are passed to f as uint64 (varint and fixed32 fields) or []byte
+// (bytes fields).  Other field types are skipped.
+func forEachField(b []byte, f func(num protowire.Number, v uint64, bv []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errDecode
+		}
+		b = b[n:]
+
+		var v uint64
+		var bv []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			v = uint64(v32)
+		case protowire.BytesType:
+			bv, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return errDecode
+			}
+			b = b[n:]
+			continue
+		}
+		if n < 0 {
+			return errDecode
+		}
+		b = b[n:]
+		if err := f(num, v, bv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmarshalDestination decodes a Destination.
+func unmarshalDestination(b []byte) (domain Domain, address []byte, err error) {
+	err = forEachField(b, func(num protowire.Number, v uint64, bv []byte) error {
+		switch num {
+		case 1:
+			domain = Domain(v)
+		case 2:
+			address = bv
+		}
+		return nil
+	})
+	return
+}
+
+// unmarshal decodes a SignatureData.
+func (s *signatureData) unmarshal(b []byte) error {
+	return forEachField(b, func(num protowire.Number, v uint64, bv []byte) error {
+		switch num {
+		case sigFieldSignerIdentity:
+			return forEachField(bv, func(num protowire.Number, v uint64, bv []byte) error {
+				if num == signerIdentityFieldPublicKey {
+					s.publicKey = bv
+				}
+				return nil
+			})
+		case sigFieldAESGCMPersonalized, sigFieldHMACPersonalized, sigFieldAESGCMResponse, sigFieldSessionInfoTag:
+			s.kind = int(num)
+			return forEachField(bv, func(field protowire.Number, v uint64, bv []byte) error {
+				switch s.kind {
+				case sigFieldAESGCMPersonalized:
+					switch field {
+					case 1:
+						s.epoch = bv
+					case 2:
+						s.nonce = bv
+					case 3:
+						s.counter = uint32(v)
+					case 4:
+						s.expiresAt = uint32(v)
+					case 5:
+						s.tag = bv
+					}
+				case sigFieldHMACPersonalized:
+					switch field {
+					case 1:
+						s.epoch = bv
+					case 2:
+						s.counter = uint32(v)
+					case 3:
+						s.expiresAt = uint32(v)
+					case 4:
+						s.tag = bv
+					}
+				case sigFieldAESGCMResponse:
+					switch field {
+					case 1:
+						s.nonce = bv
+					case 2:
+						s.counter = uint32(v)
+					case 3:
+						s.tag = bv
+					}
+				case sigFieldSessionInfoTag:
+					if field == 1 {
+						s.tag = bv
+					}
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// unmarshal decodes a RoutableMessage.
+func (m *routableMessage) unmarshal(b []byte) error {
+	return forEachField(b, func(num protowire.Number, v uint64, bv []byte) error {
+		var err error
+		switch num {
+		case 6:
+			m.toDomain, m.toAddress, err = unmarshalDestination(bv)
+		case 7:
+			m.fromDomain, m.fromAddress, err = unmarshalDestination(bv)
+		case 10:
+			m.payload = bv
+		case 12:
+			err = forEachField(bv, func(num protowire.Number, v uint64, bv []byte) error {
+				switch num {
+				case 1:
+					m.status = OperationStatus(v)
+				case 2:
+					m.fault = Fault(v)
+				}
+				return nil
+			})
+		case 13:
+			m.sig = &signatureData{}
+			err = m.sig.unmarshal(bv)
+		case 14:
+			err = forEachField(bv, func(num protowire.Number, v uint64, bv []byte) error {
+				if num == 1 {
+					m.sessionInfoRequest = bv
+				}
+				return nil
+			})
+		case 15:
+			m.sessionInfo = bv
+		case 50:
+			m.requestUUID = bv
+		case 51:
+			m.uuid = bv
+		case 52:
+			m.flags = uint32(v)
+		}
+		return err
+	})
+}
+
+// unmarshal decodes a SessionInfo.
+func (si *sessionInfo) unmarshal(b []byte) error {
+	return forEachField(b, func(num protowire.Number, v uint64, bv []byte) error {
+		switch num {
+		case 1:
+			si.counter = uint32(v)
+		case 2:
+			si.publicKey = bv
+		case 3:
+			si.epoch = bv
+		case 4:
+			si.clockTime = uint32(v)
+		case 5:
+			si.status = int(v)
+		}
+		return nil
+	})
+}
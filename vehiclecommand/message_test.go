@@ -0,0 +1,195 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package vehiclecommand
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// The golden messages here are assembled by hand from the field
+// numbers and types in universal_message.proto and signatures.proto,
+// so that they don't depend on the encoder being tested.
+
+// unhex decodes hex with optional spaces, for golden messages.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		t.Fatalf("bad golden hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestRoutableMessageGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    routableMessage
+		golden string
+	}{
+		{
+			name: "session info request",
+			msg: routableMessage{
+				toDomain:           DomainVehicleSecurity,
+				fromAddress:        []byte{0x01, 0x02},
+				sessionInfoRequest: []byte{0x04, 0xaa, 0xbb},
+				uuid:               []byte{0x10, 0x11},
+			},
+			// to_destination(6) {domain(1)=2}
+			// from_destination(7) {routing_address(2)=0102}
+			// session_info_request(14) {public_key(1)=04aabb}
+			// uuid(51)=1011
+			golden: "32 02 0802" +
+				"3a 04 12 02 0102" +
+				"72 05 0a 03 04aabb" +
+				"9a03 02 1011",
+		},
+		{
+			name: "response",
+			msg: routableMessage{
+				toAddress:   []byte{0x01, 0x02},
+				fromDomain:  DomainInfotainment,
+				payload:     []byte{0xc0},
+				status:      OperationError,
+				fault:       FaultBusy,
+				requestUUID: []byte{0x10, 0x11},
+				flags:       1,
+			},
+			// to_destination(6) {routing_address(2)=0102}
+			// from_destination(7) {domain(1)=3}
+			// protobuf_message_as_bytes(10)=c0
+			// signedMessageStatus(12) {operation_status(1)=2, signed_message_fault(2)=1}
+			// request_uuid(50)=1011
+			// flags(52)=1
+			golden: "32 04 12 02 0102" +
+				"3a 02 0803" +
+				"52 01 c0" +
+				"62 04 0802 1001" +
+				"9203 02 1011" +
+				"a003 01",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			golden := unhex(t, tt.golden)
+			if got := tt.msg.marshal(); !bytes.Equal(got, golden) {
+				t.Errorf("marshal:\ngot  %x\nwant %x", got, golden)
+			}
+			var m routableMessage
+			if err := m.unmarshal(golden); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got := m.marshal(); !bytes.Equal(got, golden) {
+				t.Errorf("round trip:\ngot  %x\nwant %x", got, golden)
+			}
+		})
+	}
+}
+
+func TestSignatureDataGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		sig    signatureData
+		golden string
+	}{
+		{
+			name: "HMAC_Personalized",
+			sig: signatureData{
+				kind:      sigFieldHMACPersonalized,
+				publicKey: []byte{0x04, 0x01},
+				epoch:     []byte{0xe0, 0xe1},
+				counter:   7,
+				expiresAt: 0x01020304,
+				tag:       []byte{0xaa, 0xbb},
+			},
+			// signer_identity(1) {public_key(1)=0401}
+			// HMAC_Personalized_data(8) {epoch(1)=e0e1, counter(2)=7,
+			//     expires_at(3)=fixed32 0x01020304, tag(4)=aabb}
+			golden: "0a 04 0a 02 0401" +
+				"42 0f 0a 02 e0e1 10 07 1d 04030201 22 02 aabb",
+		},
+		{
+			name: "AES_GCM_Personalized",
+			sig: signatureData{
+				kind:      sigFieldAESGCMPersonalized,
+				publicKey: []byte{0x04, 0x01},
+				epoch:     []byte{0xe0, 0xe1},
+				nonce:     []byte{0x90, 0x91, 0x92},
+				counter:   7,
+				expiresAt: 0x01020304,
+				tag:       []byte{0xaa, 0xbb},
+			},
+			// signer_identity(1) {public_key(1)=0401}
+			// AES_GCM_Personalized_data(5) {epoch(1)=e0e1, nonce(2)=909192,
+			//     counter(3)=7, expires_at(4)=fixed32 0x01020304, tag(5)=aabb}
+			golden: "0a 04 0a 02 0401" +
+				"2a 14 0a 02 e0e1 12 03 909192 18 07 25 04030201 2a 02 aabb",
+		},
+		{
+			name: "AES_GCM_Response",
+			sig: signatureData{
+				kind:    sigFieldAESGCMResponse,
+				nonce:   []byte{0x90, 0x91, 0x92},
+				counter: 3,
+				tag:     []byte{0xaa, 0xbb},
+			},
+			// AES_GCM_Response_data(9) {nonce(1)=909192, counter(2)=3, tag(3)=aabb}
+			golden: "4a 0b 0a 03 909192 10 03 1a 02 aabb",
+		},
+		{
+			name: "session_info_tag",
+			sig: signatureData{
+				kind: sigFieldSessionInfoTag,
+				tag:  []byte{0xaa, 0xbb},
+			},
+			// session_info_tag(6) {tag(1)=aabb}
+			golden: "32 04 0a 02 aabb",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			golden := unhex(t, tt.golden)
+			if got := tt.sig.marshal(); !bytes.Equal(got, golden) {
+				t.Errorf("marshal:\ngot  %x\nwant %x", got, golden)
+			}
+			var s signatureData
+			if err := s.unmarshal(golden); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got := s.marshal(); !bytes.Equal(got, golden) {
+				t.Errorf("round trip:\ngot  %x\nwant %x", got, golden)
+			}
+		})
+	}
+}
+
+func TestSessionInfoGolden(t *testing.T) {
+	info := sessionInfo{
+		counter:   5,
+		publicKey: []byte{0x04, 0x01},
+		epoch:     []byte{0xe0, 0xe1},
+		clockTime: 3590,
+		status:    1,
+	}
+	// counter(1)=5, publicKey(2)=0401, epoch(3)=e0e1,
+	// clock_time(4)=fixed32 3590, status(5)=1
+	golden := unhex(t, "08 05 12 02 0401 1a 02 e0e1 25 060e0000 28 01")
+	if got := info.marshal(); !bytes.Equal(got, golden) {
+		t.Errorf("marshal:\ngot  %x\nwant %x", got, golden)
+	}
+	var si sessionInfo
+	if err := si.unmarshal(golden); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if si.counter != 5 || si.clockTime != 3590 || si.status != 1 || !bytes.Equal(si.epoch, info.epoch) {
+		t.Errorf("got %+v", si)
+	}
+}
@@ -0,0 +1,398 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package vehiclecommand
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+//
+// Sessions
+//
+// A session is established by sending our public key to a vehicle
+// domain, which replies with its own public key, an epoch, a counter
+// and its clock, authenticated with an HMAC.  Both sides derive the
+// session key from the ECDH shared secret.  Each command carries an
+// increasing counter and an expiry time (in the vehicle's clock), and
+// is authenticated along with metadata identifying the vehicle,
+// domain and session.  Metadata is a sequence of tag-length-value
+// items in increasing tag order, terminated by tagEnd.
+//
+
+// Signature types, as they appear in metadata
+const (
+	sigTypeAESGCMPersonalized = 5
+	sigTypeHMAC               = 6
+	sigTypeHMACPersonalized   = 8
+	sigTypeAESGCMResponse     = 9
+)
+
+// Metadata tags
+const (
+	tagSignatureType   = 0
+	tagDomain          = 1
+	tagPersonalization = 2
+	tagEpoch           = 3
+	tagExpiresAt       = 4
+	tagCounter         = 5
+	tagChallenge       = 6
+	tagFlags           = 7
+	tagRequestHash     = 8
+	tagFault           = 9
+	tagEnd             = 255
+)
+
+// Labels for keys derived from the session key
+const (
+	labelSessionInfo   = "session info"
+	labelAuthenticated = "authenticated command"
+)
+
+// metadata builds the metadata that is authenticated with a message.
+type metadata struct {
+	b    []byte
+	last int
+	err  error
+}
+
+func newMetadata() *metadata {
+	return &metadata{last: -1}
+}
+
+// add appends an item.  Items must be added in increasing tag order.
+func (m *metadata) add(tag int, value []byte) {
+	if m.err != nil {
+		return
+	}
+	if tag <= m.last {
+		m.err = errors.New("metadata out of order")
+		return
+	}
+	if len(value) > 255 {
+		m.err = errors.New("metadata item too long")
+		return
+	}
+	m.last = tag
+	m.b = append(m.b, byte(tag), byte(len(value)))
+	m.b = append(m.b, value...)
+}
+
+// addUint32 appends an item with a 32-bit big-endian value.
+func (m *metadata) addUint32(tag int, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	m.add(tag, b[:])
+}
+
+// bytes returns the encoded metadata.
+func (m *metadata) bytes() ([]byte, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return append(m.b, tagEnd), nil
+}
+
+// commandMetadata returns the metadata for a command.
+func commandMetadata(sigType int, domain Domain, vin string, epoch []byte, expiresAt uint32, counter uint32, flags uint32) ([]byte, error) {
+	m := newMetadata()
+	m.add(tagSignatureType, []byte{byte(sigType)})
+	m.add(tagDomain, []byte{byte(domain)})
+	m.add(tagPersonalization, []byte(vin))
+	m.add(tagEpoch, epoch)
+	m.addUint32(tagExpiresAt, expiresAt)
+	m.addUint32(tagCounter, counter)
+	if flags != 0 {
+		m.addUint32(tagFlags, flags)
+	}
+	return m.bytes()
+}
+
+// responseMetadata returns the metadata for a response to a command.
+func responseMetadata(domain Domain, vin string, counter uint32, flags uint32, requestHash []byte, fault Fault) ([]byte, error) {
+	m := newMetadata()
+	m.add(tagSignatureType, []byte{sigTypeAESGCMResponse})
+	m.add(tagDomain, []byte{byte(domain)})
+	m.add(tagPersonalization, []byte(vin))
+	m.addUint32(tagCounter, counter)
+	m.addUint32(tagFlags, flags)
+	m.add(tagRequestHash, requestHash)
+	m.addUint32(tagFault, uint32(fault))
+	return m.bytes()
+}
+
+// sessionInfoMetadata returns the metadata for session info sent in
+// response to a request with the given challenge.
+func sessionInfoMetadata(vin string, challenge []byte) ([]byte, error) {
+	m := newMetadata()
+	m.add(tagSignatureType, []byte{sigTypeHMAC})
+	m.add(tagPersonalization, []byte(vin))
+	m.add(tagChallenge, challenge)
+	return m.bytes()
+}
+
+// sessionKey derives the session key from an ECDH shared secret.
+func sessionKey(shared []byte) []byte {
+	h := sha1.Sum(shared)
+	return h[:16]
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the concatenated data.
+func hmacSHA256(key []byte, data ...[]byte) []byte {
+	h := hmac.New(sha256.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// newGCM returns an AES-GCM cipher for a session key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// randomBytes returns n random bytes.
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// sealAESGCM encrypts plaintext, returning the nonce, ciphertext and
+// tag separately.  The metadata is authenticated via its hash.
+func sealAESGCM(key []byte, meta []byte, plaintext []byte) (nonce, ciphertext, tag []byte, err error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nonce, err = randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	aad := sha256.Sum256(meta)
+	sealed := gcm.Seal(nil, nonce, plaintext, aad[:])
+	n := len(sealed) - gcm.Overhead()
+	return nonce, sealed[:n], sealed[n:], nil
+}
+
+// openAESGCM decrypts and authenticates a message sealed by sealAESGCM.
+func openAESGCM(key []byte, meta []byte, nonce, ciphertext, tag []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("bad nonce")
+	}
+	aad := sha256.Sum256(meta)
+	sealed := append(append([]byte{}, ciphertext...), tag...)
+	return gcm.Open(nil, nonce, sealed, aad[:])
+}
+
+// requestHash identifies a command in the response to it.
+func requestHash(sigType int, tag []byte) []byte {
+	return append([]byte{byte(sigType)}, tag...)
+}
+
+// A Session is an authenticated session with one domain of a vehicle.
+// It is safe for concurrent use.
+type Session struct {
+	domain Domain
+	vin    string
+	pub    []byte // our public key
+	key    []byte
+	epoch  []byte
+
+	mu        sync.Mutex
+	counter   uint32
+	clockTime uint32
+	clockAt   time.Time
+}
+
+// newSession checks the session info sent by the vehicle in response
+// to a request with the given challenge, and returns a Session.
+func newSession(priv *ecdsa.PrivateKey, vin string, domain Domain, rawInfo []byte, tag []byte, challenge []byte) (*Session, error) {
+	var info sessionInfo
+	err := info.unmarshal(rawInfo)
+	if err != nil {
+		return nil, err
+	}
+	key, err := sharedKey(priv, info.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := sessionInfoMetadata(vin, challenge)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(tag, hmacSHA256(hmacSHA256(key, []byte(labelSessionInfo)), meta, rawInfo)) {
+		return nil, errors.New("session info failed authentication")
+	}
+
+	return &Session{
+		domain:    domain,
+		vin:       vin,
+		pub:       PublicKeyBytes(&priv.PublicKey),
+		key:       key,
+		epoch:     info.epoch,
+		counter:   info.counter,
+		clockTime: info.clockTime,
+		clockAt:   time.Now(),
+	}, nil
+}
+
+// Domain returns the vehicle domain the session is with.
+func (s *Session) Domain() Domain {
+	return s.domain
+}
+
+// next returns the counter and expiry time for a new command.
+func (s *Session) next(ttl time.Duration) (counter uint32, expiresAt uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counter++
+	elapsed := uint32(time.Since(s.clockAt) / time.Second)
+	return s.counter, s.clockTime + elapsed + uint32(ttl/time.Second)
+}
+
+// encrypt encrypts and authenticates a command into msg, with
+// AES-GCM.  Returns the hash that identifies the command in the
+// vehicle's response.
+func (s *Session) encrypt(msg *routableMessage, payload []byte, ttl time.Duration) ([]byte, error) {
+	counter, expiresAt := s.next(ttl)
+	meta, err := commandMetadata(sigTypeAESGCMPersonalized, s.domain, s.vin, s.epoch, expiresAt, counter, msg.flags)
+	if err != nil {
+		return nil, err
+	}
+	nonce, ciphertext, tag, err := sealAESGCM(s.key, meta, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.payload = ciphertext
+	msg.sig = &signatureData{
+		kind:      sigFieldAESGCMPersonalized,
+		publicKey: s.pub,
+		epoch:     s.epoch,
+		nonce:     nonce,
+		counter:   counter,
+		expiresAt: expiresAt,
+		tag:       tag,
+	}
+	return requestHash(sigTypeAESGCMPersonalized, tag), nil
+}
+
+// authenticate authenticates a command into msg with an HMAC, without
+// encrypting it.  Returns the hash that identifies the command in the
+// vehicle's response.
+func (s *Session) authenticate(msg *routableMessage, payload []byte, ttl time.Duration) ([]byte, error) {
+	counter, expiresAt := s.next(ttl)
+	meta, err := commandMetadata(sigTypeHMACPersonalized, s.domain, s.vin, s.epoch, expiresAt, counter, msg.flags)
+	if err != nil {
+		return nil, err
+	}
+	tag := hmacSHA256(hmacSHA256(s.key, []byte(labelAuthenticated)), meta, payload)
+
+	msg.payload = payload
+	msg.sig = &signatureData{
+		kind:      sigFieldHMACPersonalized,
+		publicKey: s.pub,
+		epoch:     s.epoch,
+		counter:   counter,
+		expiresAt: expiresAt,
+		tag:       tag,
+	}
+	return requestHash(sigTypeHMACPersonalized, tag), nil
+}
+
+// openCommand checks (and if necessary decrypts) a command, given the
+// session key.  Returns the command and its request hash.  This is
+// the vehicle's side of encrypt and authenticate.
+func openCommand(key []byte, vin string, msg *routableMessage) ([]byte, []byte, error) {
+	sig := msg.sig
+	switch sig.kind {
+	case sigFieldAESGCMPersonalized:
+		meta, err := commandMetadata(sigTypeAESGCMPersonalized, msg.toDomain, vin, sig.epoch, sig.expiresAt, sig.counter, msg.flags)
+		if err != nil {
+			return nil, nil, err
+		}
+		payload, err := openAESGCM(key, meta, sig.nonce, msg.payload, sig.tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		return payload, requestHash(sigTypeAESGCMPersonalized, sig.tag), nil
+	case sigFieldHMACPersonalized:
+		meta, err := commandMetadata(sigTypeHMACPersonalized, msg.toDomain, vin, sig.epoch, sig.expiresAt, sig.counter, msg.flags)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !hmac.Equal(sig.tag, hmacSHA256(hmacSHA256(key, []byte(labelAuthenticated)), meta, msg.payload)) {
+			return nil, nil, errors.New("bad HMAC")
+		}
+		return msg.payload, requestHash(sigTypeHMACPersonalized, sig.tag), nil
+	}
+	return nil, nil, errors.New("unsupported signature type")
+}
+
+// sealResponse encrypts a response to a command into msg.  This is the
+// vehicle's side of openResponse.
+func sealResponse(key []byte, vin string, msg *routableMessage, counter uint32, reqHash []byte, payload []byte) error {
+	meta, err := responseMetadata(msg.fromDomain, vin, counter, msg.flags, reqHash, msg.fault)
+	if err != nil {
+		return err
+	}
+	nonce, ciphertext, tag, err := sealAESGCM(key, meta, payload)
+	if err != nil {
+		return err
+	}
+	msg.payload = ciphertext
+	msg.sig = &signatureData{
+		kind:    sigFieldAESGCMResponse,
+		nonce:   nonce,
+		counter: counter,
+		tag:     tag,
+	}
+	return nil
+}
+
+// openResponse decrypts and authenticates the vehicle's response to a
+// command with the given request hash.
+func (s *Session) openResponse(msg *routableMessage, reqHash []byte) ([]byte, error) {
+	sig := msg.sig
+	if sig == nil || sig.kind != sigFieldAESGCMResponse {
+		if len(msg.payload) == 0 {
+			// Nothing to authenticate
+			return nil, nil
+		}
+		return nil, errors.New("response is not authenticated")
+	}
+	meta, err := responseMetadata(msg.fromDomain, s.vin, sig.counter, msg.flags, reqHash, msg.fault)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := openAESGCM(s.key, meta, sig.nonce, msg.payload, sig.tag)
+	if err != nil {
+		return nil, errors.New("response failed authentication")
+	}
+	return payload, nil
+}
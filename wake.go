@@ -0,0 +1,172 @@
+//
+// Copyright (C) 2021 Bruce A. Mah.
+// All rights reserved.
+//
+// Distributed under a BSD-style license, see the LICENSE file for
+// more information.
+//
+
+package gotesla
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//
+// Waking vehicles
+//
+
+// VehicleStateOnline is the Vehicle.State of a vehicle that is awake
+// and able to respond to data requests and commands.  Other values
+// include "asleep" and "offline".
+const VehicleStateOnline = "online"
+
+// Polling intervals used while waiting for a vehicle to come online.
+// The interval starts at wakePollInitial and doubles up to wakePollMax.
+var wakePollInitial = 2 * time.Second
+var wakePollMax = 16 * time.Second
+
+// WakeUpResponse is the response to a wake_up call.
+type WakeUpResponse struct {
+	Response Vehicle `json:"response"`
+}
+
+// WithAutoWake makes vehicle data calls wake the vehicle if it is
+// asleep, waiting up to timeout for it to come online before fetching
+// the data again.  A timeout of zero (the default) disables this.
+func WithAutoWake(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.autoWakeTimeout = timeout
+	}
+}
+
+// WakeUp asks a vehicle to wake up.  The vehicle usually takes some
+// time to actually come online; the returned Vehicle shows its state
+// at the time of the request.
+func (c *Client) WakeUp(ctx context.Context, ids string) (*Vehicle, error) {
+	var verbose = false
+	var wr WakeUpResponse
+
+	vehiclejson, err := c.PostTesla(ctx, "/api/1/vehicles/"+ids+"/wake_up", nil)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		fmt.Printf("Response: %s\n", vehiclejson)
+	}
+
+	err = json.Unmarshal(vehiclejson, &wr)
+	if err != nil {
+		return nil, err
+	}
+	return &(wr.Response), nil
+}
+
+// WakeUp is the package-level equivalent of Client.WakeUp.
+func WakeUp(client *http.Client, token *Token, ids string) (*Vehicle, error) {
+	return newLegacyClient(client, token).WakeUp(context.Background(), ids)
+}
+
+// WaitForOnline polls the vehicles list until the vehicle with the
+// given ID reports that it is online, or until ctx is done.  Use
+// context.WithTimeout or context.WithDeadline to limit the wait.
+func (c *Client) WaitForOnline(ctx context.Context, ids string) (*Vehicle, error) {
+	return c.waitForOnline(ctx, ids, false)
+}
+
+// WakeUpAndWait wakes a vehicle and waits for it to come online, or
+// until ctx is done.  The wake_up request is repeated at each poll,
+// since a single request doesn't always wake the vehicle.
+func (c *Client) WakeUpAndWait(ctx context.Context, ids string) (*Vehicle, error) {
+	return c.waitForOnline(ctx, ids, true)
+}
+
+// WakeUpAndWait is the package-level equivalent of Client.WakeUpAndWait,
+// waiting at most timeout for the vehicle to come online.
+func WakeUpAndWait(client *http.Client, token *Token, ids string, timeout time.Duration) (*Vehicle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return newLegacyClient(client, token).WakeUpAndWait(ctx, ids)
+}
+
+// waitForOnline is the common code for WaitForOnline and WakeUpAndWait.
+func (c *Client) waitForOnline(ctx context.Context, ids string, wake bool) (*Vehicle, error) {
+	var verbose = false
+
+	interval := wakePollInitial
+	for {
+		if wake {
+			v, err := c.WakeUp(ctx, ids)
+			if err != nil {
+				return nil, err
+			}
+			if v.State == VehicleStateOnline {
+				return v, nil
+			}
+		}
+
+		v, err := c.findVehicle(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		if verbose {
+			fmt.Printf("Vehicle %s state %s\n", ids, v.State)
+		}
+		if v.State == VehicleStateOnline {
+			return v, nil
+		}
+
+		err = sleepContext(ctx, interval)
+		if err != nil {
+			return nil, fmt.Errorf("vehicle %s not online (state %s): %w", ids, v.State, err)
+		}
+		interval *= 2
+		if interval > wakePollMax {
+			interval = wakePollMax
+		}
+	}
+}
+
+// findVehicle returns the entry in the vehicles list for the vehicle
+// with the given ID.
+func (c *Client) findVehicle(ctx context.Context, ids string) (*Vehicle, error) {
+	vehicles, err := c.GetVehicles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range *vehicles {
+		if v.Vehicle != nil && v.IDS == ids {
+			return v.Vehicle, nil
+		}
+	}
+	return nil, fmt.Errorf("vehicle %s not found", ids)
+}
+
+// getVehicleData performs a GET request for data about a specific
+// vehicle.  If the vehicle is asleep and the Client was created with
+// WithAutoWake, it wakes the vehicle and tries again.
+func (c *Client) getVehicleData(ctx context.Context, ids string, endpoint string) ([]byte, error) {
+	if c.fleet {
+		if state, ok := fleetDataRequest(endpoint); ok {
+			return c.getFleetState(ctx, ids, state)
+		}
+	}
+
+	body, err := c.GetTesla(ctx, endpoint)
+	if err == nil || c.autoWakeTimeout <= 0 || !IsVehicleAsleep(err) {
+		return body, err
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, c.autoWakeTimeout)
+	_, werr := c.WakeUpAndWait(wctx, ids)
+	cancel()
+	if werr != nil {
+		return nil, werr
+	}
+
+	return c.GetTesla(ctx, endpoint)
+}